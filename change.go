@@ -0,0 +1,398 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TagChange is a change planned to the ID3v1 tag of a file.  Commands
+// modifying files build a TagChange for every file, which a dry run
+// prints and writeTagChange applies.
+type TagChange struct {
+	Path   string
+	What   string       // Description of the change, e.g. "ID3v1 tag added"
+	Old    *ID3v1Fields // Fields of the current tag, or nil if there is none
+	New    *ID3v1Fields
+	Given  *ID3v1Fields // Fields given before New shortened them, or nil
+	Offset int64        // Offset of the current tag, or the end of the file
+}
+
+// FieldChange is the change planned to a single field of an ID3v1 tag.
+type FieldChange struct {
+	Name      string
+	Old       string // Current value, or "" if the file has no tag
+	New       string // Proposed value
+	Stored    string // Proposed value as it will read back from the tag
+	Truncated bool   // Whether the proposed value exceeds the field
+	Shortened bool   // Whether the proposed value was shortened by rules
+}
+
+// Changed returns true if the field will read back differently.
+func (c FieldChange) Changed() bool {
+	return c.Old != c.Stored
+}
+
+func (c FieldChange) String() string {
+	if !c.Changed() && c.New == c.Stored {
+		return fmt.Sprintf("%-8s %q (unchanged)", c.Name+":", c.Old)
+	}
+	s := fmt.Sprintf("%-8s %q -> %q", c.Name+":", c.Old, c.New)
+	switch {
+	case c.Shortened:
+		s += fmt.Sprintf(", shortened to %q", c.Stored)
+	case c.Truncated:
+		s += fmt.Sprintf(", truncated to %q", c.Stored)
+	case c.New != c.Stored:
+		s += fmt.Sprintf(", stored as %q", c.Stored)
+	}
+	return s
+}
+
+// newTagChange plans a change of the ID3v1 tag of a file described by
+// status to the given fields.
+func newTagChange(pathname string, status Mp3FileStatus, v *ID3v1Fields) *TagChange {
+	c := &TagChange{Path: pathname, New: v, Offset: status.Size}
+	if status.ID3v1 != nil {
+		c.Old = status.ID3v1.Fields()
+		c.Offset = status.ID3v1.Offset
+		c.What = "ID3v1 tag updated"
+	} else {
+		c.What = "ID3v1 tag added"
+	}
+	return c
+}
+
+// Shorten shortens the text of the proposed fields by a shortener,
+// keeping the fields given.
+func (c *TagChange) Shorten(s *titleShortener) {
+	c.Given = c.New
+	c.New = s.Shorten(*c.New)
+}
+
+// Fields compares the current and the proposed value of every field of
+// the tag, with the value the proposed tag will actually store after
+// encoding and truncation to the length of the field.  The proposed
+// value of a shortened field is the value given.
+func (c *TagChange) Fields() []FieldChange {
+	old := c.Old
+	if old == nil {
+		old = &ID3v1Fields{Genre: id3v1GenreNone}
+	}
+	given := c.Given
+	if given == nil {
+		given = c.New
+	}
+	raw := c.New.Encode()
+	t, _ := parseID3v1Tag(raw)
+	stored := t.Fields()
+	var changes []FieldChange
+	texts := [][4]string{
+		{old.Title, given.Title, c.New.Title, stored.Title},
+		{old.Artist, given.Artist, c.New.Artist, stored.Artist},
+		{old.Album, given.Album, c.New.Album, stored.Album},
+		{old.Year, given.Year, c.New.Year, stored.Year},
+		{old.Comment, given.Comment, c.New.Comment, stored.Comment},
+	}
+	for i, field := range t.fields() {
+		changes = append(changes, FieldChange{
+			Name:      field.Name,
+			Old:       texts[i][0],
+			New:       texts[i][1],
+			Stored:    texts[i][3],
+			Truncated: len(id3v1Codepage.Encode(texts[i][1])) > field.End-field.Start,
+			Shortened: texts[i][1] != texts[i][2],
+		})
+	}
+	track := strconv.Itoa(int(stored.Track))
+	genre := genreName(stored.Genre)
+	changes = append(changes,
+		FieldChange{Name: "track", Old: strconv.Itoa(int(old.Track)), New: track, Stored: track},
+		FieldChange{Name: "genre", Old: genreName(old.Genre), New: genre, Stored: genre})
+	if c.Old == nil {
+		for i := range changes {
+			changes[i].Old = ""
+		}
+	}
+	return changes
+}
+
+// Changed returns true if any field will read back differently.
+func (c *TagChange) Changed() bool {
+	if c.Old == nil {
+		return true
+	}
+	for _, f := range c.Fields() {
+		if f.Changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Unrepresentable reports the characters of the proposed fields outside
+// the codepage of ID3v1 text, which are stored as '?'.
+func (c *TagChange) Unrepresentable() []Finding {
+	var findings []Finding
+	for i, value := range []string{c.New.Title, c.New.Artist, c.New.Album, c.New.Year, c.New.Comment} {
+		if runes := id3v1Codepage.Unrepresentable(value); len(runes) > 0 {
+			findings = append(findings, Finding{"ID3v1 " + id3v1Fields[i].Name,
+				fmt.Sprintf("characters outside %s stored as '?': %s", id3v1Codepage.Name, quoteRunes(runes))})
+		}
+	}
+	return findings
+}
+
+// quoteRunes lists characters quoted and separated with commas.
+func quoteRunes(runes []rune) string {
+	var quoted []string
+	for _, r := range runes {
+		quoted = append(quoted, strconv.QuoteRune(r))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// genreName describes an ID3v1 genre number, e.g. "17 (Rock)".
+func genreName(genre byte) string {
+	switch {
+	case genre == id3v1GenreNone:
+		return "255 (none)"
+	case int(genre) < len(id3v1Genres):
+		return fmt.Sprintf("%d (%s)", genre, id3v1Genres[genre])
+	default:
+		return fmt.Sprintf("%d (undefined)", genre)
+	}
+}
+
+// Padding given to an ID3v2 tag added to a file, leaving room for
+// later changes
+const id3v2Padding = 1024
+
+// ID3v2Change is a change planned to the ID3v2 tag at the start of a
+// file, which replaces the tag with an ID3v2.3 tag.
+type ID3v2Change struct {
+	Path   string
+	What   string        // Description of the change, e.g. "ID3v2 tag added"
+	Old    *ID3v2Header  // Header of the current tag, or nil if there is none
+	Fields []FieldChange // Changes to the frames, named by their IDs
+	Tag    []byte
+}
+
+// Changed returns true if any frame changes.
+func (c *ID3v2Change) Changed() bool {
+	for _, f := range c.Fields {
+		if f.Changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// id3v2FieldNames are the names of the fields an ID3v2 tag is set from,
+// which are those of tagFieldNames and the album artist.
+var id3v2FieldNames = append(append([]string{}, tagFieldNames...), "album_artist")
+
+// id3v2FieldFrames maps the fields of id3v2FieldNames to the IDs of the
+// ID3v2.3 frames holding them.
+var id3v2FieldFrames = map[string]string{
+	"title":        "TIT2",
+	"artist":       "TPE1",
+	"album":        "TALB",
+	"year":         "TYER",
+	"comment":      "COMM",
+	"track":        "TRCK",
+	"genre":        "TCON",
+	"album_artist": "TPE2",
+}
+
+// PlanID3v2Change plans setting the frames of the ID3v2 tag at the start
+// of a file to the values of fields keyed by the names in id3v2FieldNames.
+// A file without an ID3v2 tag gets an ID3v2.3 tag; an ID3v2.3 tag is
+// updated, keeping its other frames.  ID3v2.2 and ID3v2.4 tags are not
+// updated, as they would be converted.
+func PlanID3v2Change(pathname string, status Mp3FileStatus, values map[string]string) (*ID3v2Change, error) {
+	c := &ID3v2Change{Path: pathname, What: "ID3v2.3 tag added"}
+	var frames []id3v2Frame
+	minSize := int64(0)
+	if len(status.ID3v2) > 0 && !status.ID3v2[0].Appended {
+		h := status.ID3v2[0]
+		if h.Version != 3 {
+			return nil, id3Error{pathname,
+				fmt.Sprintf("Cannot update ID3v2.%d tag, which needs --downgrade", h.Version)}
+		}
+		f, err := os.Open(pathname)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if frames, err = readID3v2Tag(f, h); err != nil {
+			return nil, id3Error{pathname, err.Error()}
+		}
+		c.Old, c.What, minSize = h, "ID3v2.3 tag updated", h.TotalSize()
+	}
+	for _, name := range id3v2FieldNames {
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		id := id3v2FieldFrames[name]
+		switch name {
+		case "track":
+			if n := id3v2Track(value); n != 0 {
+				value = strconv.Itoa(int(n))
+			}
+		case "genre":
+			if n := id3v1GenreNumber(value); n != id3v1GenreNone {
+				value = fmt.Sprintf("(%d)", n)
+			}
+		}
+		var old string
+		var kept []id3v2Frame
+		for _, f := range frames {
+			switch {
+			case f.ID != id:
+				kept = append(kept, f)
+			case id == "COMM":
+				if _, desc, text := commentFrameText(f); desc != "" || f.Encrypted {
+					kept = append(kept, f)
+				} else if old == "" {
+					old = text
+				}
+			case old == "" && !f.Encrypted:
+				old = strings.Join(textFrameValues(f), "/")
+			}
+		}
+		frames = kept
+		if value != "" {
+			if id == "COMM" {
+				frames = append(frames, newCommentFrame(value))
+			} else {
+				frames = append(frames, newTextFrame(id3v2Frame{Group: -1}, id, value))
+			}
+		}
+		c.Fields = append(c.Fields, FieldChange{Name: id, Old: old, New: value, Stored: value})
+	}
+	if minSize == 0 {
+		c.Tag = encodeID3v23Tag(frames, 0)
+		c.Tag = encodeID3v23Tag(frames, int64(len(c.Tag))+id3v2Padding)
+	} else {
+		c.Tag = encodeID3v23Tag(frames, minSize)
+	}
+	return c, nil
+}
+
+// fieldText is new text for a field of the ID3v1 tag, or for a text or
+// comment frame of the ID3v2 tag read by readID3v2Texts.
+type fieldText struct {
+	Tag   string    // "ID3v1" or "ID3v2"
+	Field string    // Name of the ID3v1 field, or ID of the ID3v2 frame
+	Text  string    // New text
+	frame id3v2Text // Frame of an ID3v2 field
+}
+
+// planTextChanges plans rewriting fields of the tags of a file with new
+// text, describing the change of each tag with what, e.g. "repaired".
+// Either change is nil if it has no field to rewrite.
+func planTextChanges(pathname string, status Mp3FileStatus, texts []fieldText, what string) (*TagChange, *ID3v2Change, error) {
+	var v1Change *TagChange
+	var v2Change *ID3v2Change
+	values := make(map[string]string)
+	var frames []fieldText
+	for _, t := range texts {
+		if t.Tag == "ID3v1" {
+			values[t.Field] = t.Text
+		} else {
+			frames = append(frames, t)
+		}
+	}
+	if len(values) > 0 {
+		v1Change = newTagChange(pathname, status, status.ID3v1.Fields().With(values))
+		v1Change.What = "ID3v1 tag " + what
+	}
+	if len(frames) > 0 {
+		var err error
+		if v2Change, err = planID3v2TextChange(pathname, status, frames); err != nil {
+			return nil, nil, err
+		}
+		v2Change.What = "ID3v2.3 tag " + what
+	}
+	return v1Change, v2Change, nil
+}
+
+// planID3v2TextChange plans rewriting text and comment frames of the
+// ID3v2.3 tag at the start of a file with new text, keeping the language
+// and the description of a comment.  Other versions of tags need
+// --downgrade first.
+func planID3v2TextChange(pathname string, status Mp3FileStatus, frames []fieldText) (*ID3v2Change, error) {
+	h := status.ID3v2[0]
+	if h.Version != 3 {
+		return nil, id3Error{pathname,
+			fmt.Sprintf("Cannot update ID3v2.%d tag, which needs --downgrade", h.Version)}
+	}
+	f, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tag, err := readID3v2Tag(f, h)
+	if err != nil {
+		return nil, id3Error{pathname, err.Error()}
+	}
+	c := &ID3v2Change{Path: pathname, What: "ID3v2.3 tag updated", Old: h}
+	for _, t := range frames {
+		old := t.frame
+		if old.ID == "COMM" {
+			tag[old.Frame] = newCommentFrameIn(tag[old.Frame], old.Language, old.Description, t.Text)
+		} else {
+			tag[old.Frame] = newTextFrame(tag[old.Frame], old.ID, t.Text)
+		}
+		c.Fields = append(c.Fields, FieldChange{Name: old.ID, Old: old.Text, New: t.Text, Stored: t.Text})
+	}
+	c.Tag = encodeID3v23Tag(tag, h.TotalSize())
+	return c, nil
+}
+
+// commentFrameText decodes the language, description and text of a COMM
+// frame.
+func commentFrameText(f id3v2Frame) (lang, desc, text string) {
+	if len(f.Data) < 4 {
+		return "", "", ""
+	}
+	desc, rest := splitID3v2String(f.Data[0], f.Data[4:])
+	return string(f.Data[1:4]), desc, strings.TrimRight(decodeID3v2Text(f.Data[0], rest), "\x00")
+}
+
+// newCommentFrame builds an ID3v2.3 COMM frame in English without a
+// description.  Text outside ISO-8859-1 is encoded in UTF-16.
+func newCommentFrame(text string) id3v2Frame {
+	return newCommentFrameIn(id3v2Frame{Group: -1}, "eng", "", text)
+}
+
+// newCommentFrameIn builds an ID3v2.3 COMM frame in a language with a
+// description, carrying over the flags of the frame it replaces.
+func newCommentFrameIn(f id3v2Frame, lang, desc, text string) id3v2Frame {
+	c := newTextFrame(f, "COMM", desc+text)
+	encoding := c.Data[0]
+	data := append([]byte{encoding}, lang...)
+	data = append(data, encodeID3v2Text(encoding, desc)...)
+	data = append(data, id3v2Terminator(encoding)...)
+	c.Data = append(data, encodeID3v2Text(encoding, text)...)
+	return c
+}
@@ -0,0 +1,81 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTagChangeFields(t *testing.T) {
+	old, _ := parseID3v1Tag(makeID3v1Tag([]byte("Comment")))
+	status := Mp3FileStatus{ID3v1: old, Size: 1000}
+	status.ID3v1.Offset = 872
+	v := old.Fields()
+	v.Title = "A Title Longer Than Thirty Characters"
+	v.Artist = "Café 日本"
+	c := newTagChange("test.mp3", status, v)
+	if c.Offset != 872 || c.What != "ID3v1 tag updated" {
+		t.Errorf("change = %+v, expected the tag at offset 872 updated", c)
+	}
+	expected := map[string]FieldChange{
+		"title": {"title", "Title", "A Title Longer Than Thirty Characters",
+			"A Title Longer Than Thirty Cha", true, false},
+		"artist":  {"artist", "Artist", "Café 日本", "Café ??", false, false},
+		"album":   {"album", "Album", "Album", "Album", false, false},
+		"comment": {"comment", "Comment", "Comment", "Comment", false, false},
+	}
+	for _, f := range c.Fields() {
+		if e, ok := expected[f.Name]; ok && f != e {
+			t.Errorf("Fields() %s = %+v, expected %+v", f.Name, f, e)
+		}
+	}
+	if !c.Changed() {
+		t.Error("Changed() = false, expected true")
+	}
+	if got := newTagChange("test.mp3", status, old.Fields()); got.Changed() {
+		t.Errorf("Changed() = true for identical fields: %v", got.Fields())
+	}
+}
+
+func TestFieldChangeString(t *testing.T) {
+	for _, tt := range []struct {
+		change   FieldChange
+		expected string
+	}{
+		{FieldChange{"album", "Album", "Album", "Album", false, false}, `album:   "Album" (unchanged)`},
+		{FieldChange{"year", "", "2020", "2020", false, false}, `year:    "" -> "2020"`},
+		{FieldChange{"title", "", "0123456789012345678901234567890", "012345678901234567890123456789", true, false},
+			`title:   "" -> "0123456789012345678901234567890", truncated to "012345678901234567890123456789"`},
+		{FieldChange{"title", "", "Song (Remastered 2011)", "Song", true, true},
+			`title:   "" -> "Song (Remastered 2011)", shortened to "Song"`},
+		{FieldChange{"artist", "", "日本", "??", false, false}, `artist:  "" -> "日本", stored as "??"`},
+	} {
+		if got := tt.change.String(); got != tt.expected {
+			t.Errorf("String() = %s, expected %s", got, tt.expected)
+		}
+	}
+}
+
+func TestWriteTagChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	frames := makeMpegFrames(4, 0xFF, 0xFB, 0x90, 0x44)
+	if err := os.WriteFile(path, append(frames, makeID3v1Tag([]byte("Comment"))...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := status.ID3v1.Fields()
+	v.Title = "New Title"
+	if err := writeTagChange(newTagChange(path, status, v), nil); err != nil {
+		t.Fatalf("writeTagChange() error = %v", err)
+	}
+	b, _ := os.ReadFile(path)
+	if len(b) != len(frames)+id3v1TagSize || !bytes.Equal(b[len(frames):], v.Encode()) {
+		t.Errorf("writeTagChange() did not overwrite the tag in place")
+	}
+}
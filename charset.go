@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "fmt"
+
+// charsetFindings reports the characters of a text field a device
+// reading a codepage cannot show: characters outside the codepage, and
+// characters falling into vendor extensions outside the standard
+// character set the codepage extends, grouped by extension.
+func charsetFindings(field string, text string, target *codepage) []Finding {
+	var findings []Finding
+	if runes := target.Unrepresentable(text); len(runes) > 0 {
+		findings = append(findings, Finding{field,
+			fmt.Sprintf("characters outside %s: %s", target.Name, quoteRunes(runes))})
+	}
+	var extensions []string
+	byExtension := make(map[string][]rune)
+	for _, r := range text {
+		extension := target.Extension(r)
+		if extension == "" {
+			continue
+		}
+		if _, ok := byExtension[extension]; !ok {
+			extensions = append(extensions, extension)
+		}
+		if !containsRune(byExtension[extension], r) {
+			byExtension[extension] = append(byExtension[extension], r)
+		}
+	}
+	for _, extension := range extensions {
+		findings = append(findings, Finding{field,
+			fmt.Sprintf("%s outside %s: %s", extension, target.Base, quoteRunes(byExtension[extension]))})
+	}
+	return findings
+}
+
+// containsRune returns true if a character is among characters.
+func containsRune(runes []rune, r rune) bool {
+	for _, c := range runes {
+		if c == r {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCharset checks the text of the fields of the ID3v1 tag, read in
+// the codepage of ID3v1 text, and of the text and comment frames of the
+// ID3v2 tag at the start of a file against the codepage of a device.
+func CheckCharset(pathname string, status Mp3FileStatus, target *codepage) ([]Finding, error) {
+	var findings []Finding
+	if status.ID3v1 != nil {
+		v := status.ID3v1.Fields()
+		for i, text := range []string{v.Title, v.Artist, v.Album, v.Year, v.Comment} {
+			findings = append(findings, charsetFindings("ID3v1 "+id3v1Fields[i].Name, text, target)...)
+		}
+	}
+	texts, err := readID3v2Texts(pathname, status)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range texts {
+		findings = append(findings, charsetFindings("ID3v2 "+t.ID, t.Text, target)...)
+	}
+	return findings, nil
+}
+
+// checkCharset prints the characters of the tags of a file a device
+// reading the codepage given by --charset cannot show.
+func checkCharset(pathname string, status Mp3FileStatus) error {
+	findings, err := CheckCharset(pathname, status, charsetTarget)
+	if err != nil || len(findings) == 0 {
+		return err
+	}
+	fmt.Printf("%s: incompatible with %s\n", pathname, charsetTarget.Name)
+	for _, f := range findings {
+		fmt.Printf("\t%s\n", f)
+	}
+	return nil
+}
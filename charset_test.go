@@ -0,0 +1,60 @@
+// +build unittest
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCharsetFindings(t *testing.T) {
+	for _, tt := range []struct {
+		codepage string
+		text     string
+		expected []Finding
+	}{
+		{"cp932", "東京タワー", nil},
+		{"cp932", "①②♥〜髙", []Finding{
+			{"title", "characters outside CP932: '♥', '〜'"},
+			{"title", "NEC special characters outside JIS X 0208: '①', '②'"},
+			{"title", "NEC-selected IBM extensions outside JIS X 0208: '髙'"},
+		}},
+		{"latin1", "Café ♥", []Finding{{"title", "characters outside ISO-8859-1: '♥'"}}},
+		{"cp1252", "€5", []Finding{{"title", "Windows extensions outside ISO-8859-1: '€'"}}},
+		{"euc-kr", "한국 똠", []Finding{{"title", "Unified Hangul Code extensions outside KS X 1001: '똠'"}}},
+		{"gbk", "中文 丂", []Finding{{"title", "GBK extensions outside GB 2312: '丂'"}}},
+	} {
+		cp, _ := lookupCodepage(tt.codepage)
+		if got := charsetFindings("title", tt.text, cp); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("charsetFindings(%q) in %s = %v, expected %v", tt.text, tt.codepage, got, tt.expected)
+		}
+	}
+}
+
+func TestCheckCharset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	id3v2Tag := makeID3v2TagWithFrames(4,
+		makeID3v24Frame("TIT2", 0, append([]byte{id3v2EncodingUTF8}, "Love ♥ ①"...)),
+		makeID3v24Frame("TPE1", 0, append([]byte{id3v2EncodingUTF8}, "Artist"...)))
+	content := append(append(id3v2Tag, makeMpegFrames(4, 0xFF, 0xFB, 0x90, 0x44)...), makeID3v1Tag(nil)...)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := CheckCharset(path, status, codepages["cp932"])
+	if err != nil {
+		t.Fatalf("CheckCharset() error = %v", err)
+	}
+	expected := []Finding{
+		{"ID3v2 TIT2", "characters outside CP932: '♥'"},
+		{"ID3v2 TIT2", "NEC special characters outside JIS X 0208: '①'"},
+	}
+	if !reflect.DeepEqual(findings, expected) {
+		t.Errorf("CheckCharset() = %v, expected %v", findings, expected)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// codepage is a legacy character set the text of ID3v1 tags is encoded
+// in, as ID3v1 has no field telling the encoding.
+type codepage struct {
+	Name     string // e.g. "CP932"
+	encoding encoding.Encoding
+	Base     string // Standard character set the codepage extends, e.g. "JIS X 0208"
+	vendor   func(b []byte) string
+}
+
+// codepages are the codepages of ID3v1 text, by the names --codepage
+// accepts.
+var codepages = map[string]*codepage{
+	"latin1":   {"ISO-8859-1", charmap.ISO8859_1, "ISO-8859-1", nil},
+	"cp932":    {"CP932", japanese.ShiftJIS, "JIS X 0208", cp932Extension},
+	"shiftjis": {"CP932", japanese.ShiftJIS, "JIS X 0208", cp932Extension},
+	"cp1251":   {"CP1251", charmap.Windows1251, "CP1251", nil},
+	"cp1252":   {"CP1252", charmap.Windows1252, "ISO-8859-1", cp1252Extension},
+	"euc-kr":   {"EUC-KR", korean.EUCKR, "KS X 1001", uhcExtension},
+	"gbk":      {"GBK", simplifiedchinese.GBK, "GB 2312", gbkExtension},
+}
+
+// id3v1Codepage is the codepage ID3v1 text is read and written in,
+// selected by --codepage.
+var id3v1Codepage = codepages["latin1"]
+
+// lookupCodepage returns the codepage of a name --codepage accepts,
+// regardless of case.
+func lookupCodepage(name string) (*codepage, bool) {
+	cp, ok := codepages[strings.ToLower(name)]
+	return cp, ok
+}
+
+// Encode encodes a string, replacing characters outside the codepage
+// with '?'.
+func (cp *codepage) Encode(s string) []byte {
+	b := make([]byte, 0, len(s))
+	e := cp.encoding.NewEncoder()
+	for _, r := range s {
+		c, err := e.Bytes([]byte(string(r)))
+		if err != nil {
+			c = []byte{'?'}
+		}
+		b = append(b, c...)
+	}
+	return b
+}
+
+// Decode decodes a string of bytes in the codepage.  Bytes not making
+// a character are decoded as U+FFFD.
+func (cp *codepage) Decode(s string) string {
+	d, err := cp.encoding.NewDecoder().String(s)
+	if err != nil {
+		return decodeLatin1(s)
+	}
+	return d
+}
+
+// Unrepresentable returns the characters of a string outside the
+// codepage, each once in the order they appear.
+func (cp *codepage) Unrepresentable(s string) []rune {
+	var runes []rune
+	e := cp.encoding.NewEncoder()
+	for _, r := range s {
+		if _, err := e.String(string(r)); err != nil && !containsRune(runes, r) {
+			runes = append(runes, r)
+		}
+	}
+	return runes
+}
+
+// Extension returns the name of the vendor extension of the codepage a
+// character falls into, outside the standard character set the
+// codepage extends, or "" if it falls into none or is outside the
+// codepage.
+func (cp *codepage) Extension(r rune) string {
+	if cp.vendor == nil {
+		return ""
+	}
+	b, err := cp.encoding.NewEncoder().Bytes([]byte(string(r)))
+	if err != nil {
+		return ""
+	}
+	return cp.vendor(b)
+}
+
+// cp932Extension names the extensions of Windows to JIS X 0208 in CP932.
+func cp932Extension(b []byte) string {
+	if len(b) != 2 {
+		return ""
+	}
+	switch code := int(b[0])<<8 | int(b[1]); {
+	case code >= 0x8740 && code <= 0x879C:
+		return "NEC special characters"
+	case code >= 0xED40 && code <= 0xEEFC:
+		return "NEC-selected IBM extensions"
+	case code >= 0xF040 && code <= 0xF9FC:
+		return "user-defined characters"
+	case code >= 0xFA40 && code <= 0xFC4B:
+		return "IBM extensions"
+	}
+	return ""
+}
+
+// cp1252Extension names the characters Windows added to ISO-8859-1 in
+// CP1252.
+func cp1252Extension(b []byte) string {
+	if len(b) == 1 && b[0] >= 0x80 && b[0] <= 0x9F {
+		return "Windows extensions"
+	}
+	return ""
+}
+
+// uhcExtension names the Unified Hangul Code extending KS X 1001 in the
+// EUC-KR of Windows.
+func uhcExtension(b []byte) string {
+	if len(b) == 2 && (b[0] < 0xA1 || b[1] < 0xA1) {
+		return "Unified Hangul Code extensions"
+	}
+	return ""
+}
+
+// gbkExtension names the characters GBK added to GB 2312.
+func gbkExtension(b []byte) string {
+	if len(b) == 2 && (b[0] < 0xA1 || b[0] > 0xF7 || b[1] < 0xA1) {
+		return "GBK extensions"
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCodepage(t *testing.T) {
+	tests := []struct {
+		name            string
+		text            string
+		encoded         []byte
+		unrepresentable []rune
+	}{
+		{"latin1", "Café ♥", []byte("Caf\xe9 ?"), []rune{'♥'}},
+		{"cp932", "日本 ①♥", []byte("\x93\xfa\x96\x7b \x87\x40?"), []rune{'♥'}},
+		{"cp1251", "Кино", []byte("\xca\xe8\xed\xee"), nil},
+		{"cp1252", "€ Ж", []byte("\x80 ?"), []rune{'Ж'}},
+		{"euc-kr", "한국", []byte("\xc7\xd1\xb1\xb9"), nil},
+		{"gbk", "中文 あ", []byte("\xd6\xd0\xce\xc4 \xa4\xa2"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, ok := lookupCodepage(tt.name)
+			if !ok {
+				t.Fatalf("lookupCodepage(%q) failed", tt.name)
+			}
+			if got := cp.Encode(tt.text); !bytes.Equal(got, tt.encoded) {
+				t.Errorf("Encode(%q) = %q, expected %q", tt.text, got, tt.encoded)
+			}
+			if got := cp.Unrepresentable(tt.text); !reflect.DeepEqual(got, tt.unrepresentable) {
+				t.Errorf("Unrepresentable(%q) = %q, expected %q", tt.text, got, tt.unrepresentable)
+			}
+			if tt.unrepresentable == nil {
+				if got := cp.Decode(string(tt.encoded)); got != tt.text {
+					t.Errorf("Decode() = %q, expected %q", got, tt.text)
+				}
+			}
+		})
+	}
+	if _, ok := lookupCodepage("utf-8"); ok {
+		t.Error("lookupCodepage() accepted UTF-8")
+	}
+}
+
+func TestID3v1FieldsInCP932(t *testing.T) {
+	defer func(cp *codepage) { id3v1Codepage = cp }(id3v1Codepage)
+	id3v1Codepage, _ = lookupCodepage("ShiftJIS")
+
+	// 15 kana take 30 bytes, so the 16th is cut rather than split
+	v := &ID3v1Fields{Title: "あいうえおかきくけこさしすせそた", Artist: "♥", Track: 1, Genre: 17}
+	tag, _ := parseID3v1Tag(v.Encode())
+	fields := tag.Fields()
+	if fields.Title != "あいうえおかきくけこさしすせそ" || fields.Artist != "?" {
+		t.Errorf("Fields() = %+v", fields)
+	}
+	c := &TagChange{Path: "test.mp3", New: v}
+	expected := []Finding{{"ID3v1 artist", "characters outside CP932 stored as '?': '♥'"}}
+	if got := c.Unrepresentable(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unrepresentable() = %v, expected %v", got, expected)
+	}
+	if err := checkID3v1Values(map[string]string{"artist": "日本"}, false); err != nil {
+		t.Errorf("checkID3v1Values() error = %v", err)
+	}
+	if err := checkID3v1Values(map[string]string{"title": "あいうえおかきくけこさしすせそた"}, false); err == nil {
+		t.Error("checkID3v1Values() accepted a 32-byte title")
+	}
+}
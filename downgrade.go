@@ -0,0 +1,306 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Frames of ID3v2.4 that ID3v2.3 does not define and that have no
+// counterpart in ID3v2.3
+var id3v24OnlyFrames = map[string]bool{
+	"ASPI": true, "EQU2": true, "RVA2": true, "SEIP": true, "SIGN": true,
+	"TDEN": true, "TDRL": true, "TDTG": true, "TMOO": true, "TPRO": true,
+	"TSST": true,
+}
+
+// Frames of ID3v2.4 renamed to the frames ID3v2.3 players commonly use
+// for them
+var id3v24RenamedFrames = map[string]string{
+	"TSOA": "XSOA",
+	"TSOP": "XSOP",
+	"TSOT": "XSOT",
+}
+
+// Frames whose first byte is a text encoding, other than text frames
+var id3v2EncodedFrames = map[string]bool{
+	"COMM": true, "USLT": true, "APIC": true, "WXXX": true, "GEOB": true,
+	"SYLT": true, "USER": true, "OWNE": true, "COMR": true, "IPLS": true,
+}
+
+// DowngradeChange is the conversion of the ID3v2.4 tag at the start of a
+// file to ID3v2.3.
+type DowngradeChange struct {
+	Path   string
+	Old    *ID3v2Header
+	Tag    []byte   // ID3v2.3 tag replacing the old one
+	Losses []string // Information lost in the conversion
+}
+
+func (c *DowngradeChange) String() string {
+	return fmt.Sprintf("ID3v2.%d tag downgraded to ID3v2.3", c.Old.Version)
+}
+
+// PlanDowngrade plans the conversion of the ID3v2.4 tag at the start of
+// a file to ID3v2.3, or returns nil if the file has no such tag.  The new
+// tag is padded to the size of the old one when it fits.
+func PlanDowngrade(pathname string, status Mp3FileStatus) (*DowngradeChange, error) {
+	if len(status.ID3v2) == 0 || status.ID3v2[0].Appended || status.ID3v2[0].Version != 4 {
+		return nil, nil
+	}
+	h := status.ID3v2[0]
+	f, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	frames, err := readID3v2Tag(f, h)
+	if err != nil {
+		return nil, id3Error{pathname, err.Error()}
+	}
+	c := &DowngradeChange{Path: pathname, Old: h}
+	if h.Flags&id3v2FlagExtendedHeader != 0 {
+		c.Losses = append(c.Losses, "extended header dropped")
+	}
+	frames = downgradeFrames(frames, &c.Losses)
+	c.Tag = encodeID3v23Tag(frames, h.TotalSize())
+	return c, nil
+}
+
+// Downgrade replaces the ID3v2.4 tag of a file with the ID3v2.3 tag
+// planned by a change, recording the write in the journal unless it is
+// nil.
+func Downgrade(c *DowngradeChange, j *journal) error {
+	return writeFile(c.Path, []fileSplice{{c.Old.Offset, c.Old.TotalSize(), c.Tag}}, j)
+}
+
+// downgradeFrames converts ID3v2.4 frames to ID3v2.3, adding the
+// information lost to losses.
+func downgradeFrames(frames []id3v2Frame, losses *[]string) []id3v2Frame {
+	lose := func(format string, a ...interface{}) {
+		*losses = append(*losses, fmt.Sprintf(format, a...))
+	}
+	var out []id3v2Frame
+	var people []string // Pairs of involvement and people for IPLS
+	for _, f := range frames {
+		switch {
+		case f.Encrypted:
+			lose("encrypted %s frame dropped", f.ID)
+		case id3v24OnlyFrames[f.ID]:
+			if f.ID[0] == 'T' && len(f.Data) > 0 {
+				lose("%s frame %q dropped", f.ID, strings.Join(textFrameValues(f), "/"))
+			} else {
+				lose("%s frame dropped", f.ID)
+			}
+		case f.ID == "TIPL" || f.ID == "TMCL":
+			people = append(people, textFrameValues(f)...)
+			if f.ID == "TMCL" {
+				lose("TMCL frame merged into IPLS")
+			}
+		case f.ID == "TDRC":
+			out = append(out, downgradeTDRC(f, lose)...)
+		case f.ID == "TDOR":
+			values := textFrameValues(f)
+			if len(values) > 0 && len(values[0]) >= 4 {
+				out = append(out, newTextFrame(f, "TORY", values[0][0:4]))
+				if len(values[0]) > 4 {
+					lose("TDOR %q reduced to TORY %q", values[0], values[0][0:4])
+				}
+			}
+		case f.ID[0] == 'T' && f.ID != "TXXX":
+			values := textFrameValues(f)
+			id := f.ID
+			if renamed, ok := id3v24RenamedFrames[id]; ok {
+				id = renamed
+			}
+			var text string
+			if id == "TCON" {
+				text = downgradeGenres(values)
+			} else {
+				text = strings.Join(values, "/")
+				if len(values) > 1 {
+					lose("%s values joined with \"/\" into %q", f.ID, text)
+				}
+			}
+			out = append(out, newTextFrame(f, id, text))
+		case f.ID == "TXXX" || id3v2EncodedFrames[f.ID]:
+			if g, ok := downgradeEncodedFrame(f); ok {
+				out = append(out, g)
+			} else {
+				lose("%s frame with UTF-8 or UTF-16BE text dropped", f.ID)
+			}
+		default:
+			out = append(out, f)
+		}
+	}
+	if len(people) > 0 {
+		out = append(out, newTextFrame(id3v2Frame{Group: -1}, "IPLS", strings.Join(people, "\x00")))
+	}
+	return out
+}
+
+// textFrameValues decodes the list of values of a text frame.
+func textFrameValues(f id3v2Frame) []string {
+	if len(f.Data) == 0 {
+		return nil
+	}
+	return splitID3v2Strings(f.Data[0], f.Data[1:])
+}
+
+// newTextFrame builds an ID3v2.3 text frame carrying over the flags of
+// the frame it replaces.  Text outside ISO-8859-1 is encoded in UTF-16.
+func newTextFrame(f id3v2Frame, id, text string) id3v2Frame {
+	encoding := byte(id3v2EncodingLatin1)
+	for _, r := range text {
+		if r > 0xFF {
+			encoding = id3v2EncodingUTF16
+			break
+		}
+	}
+	var data []byte
+	for i, s := range strings.Split(text, "\x00") {
+		if i > 0 {
+			data = append(data, id3v2Terminator(encoding)...)
+		}
+		data = append(data, encodeID3v2Text(encoding, s)...)
+	}
+	return id3v2Frame{ID: id, Status: f.Status, Group: f.Group, Data: append([]byte{encoding}, data...)}
+}
+
+// downgradeTDRC converts a TDRC timestamp such as "2001-05-21T10:30"
+// into TYER, TDAT and TIME frames.
+func downgradeTDRC(f id3v2Frame, lose func(string, ...interface{})) []id3v2Frame {
+	values := textFrameValues(f)
+	if len(values) == 0 || len(values[0]) < 4 {
+		return nil
+	}
+	if len(values) > 1 {
+		lose("TDRC values after %q dropped", values[0])
+	}
+	ts := values[0]
+	frames := []id3v2Frame{newTextFrame(f, "TYER", ts[0:4])}
+	switch {
+	case len(ts) >= 10:
+		frames = append(frames, newTextFrame(f, "TDAT", ts[8:10]+ts[5:7]))
+	case len(ts) >= 7:
+		lose("month of TDRC %q dropped", ts)
+	}
+	if len(ts) >= 16 {
+		frames = append(frames, newTextFrame(f, "TIME", ts[11:13]+ts[14:16]))
+	} else if len(ts) >= 13 {
+		lose("hour of TDRC %q dropped", ts)
+	}
+	if len(ts) > 16 {
+		lose("seconds of TDRC %q dropped", ts)
+	}
+	return frames
+}
+
+// downgradeGenres converts the values of an ID3v2.4 TCON frame, where
+// genre numbers and "RX" and "CR" are written as is, into ID3v2.3 genre
+// references in parentheses followed by a refinement, e.g. "(17)Rock".
+func downgradeGenres(values []string) string {
+	var refs, names []string
+	for _, v := range values {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n < 256 || v == "RX" || v == "CR" {
+			refs = append(refs, "("+v+")")
+		} else {
+			names = append(names, v)
+		}
+	}
+	return strings.Join(refs, "") + strings.Join(names, "/")
+}
+
+// downgradeEncodedFrame re-encodes the text of a TXXX, COMM, USLT, APIC
+// or WXXX frame in UTF-8 or UTF-16BE into UTF-16 with a byte order mark.
+// Other frames whose text cannot be re-encoded are reported as not ok.
+func downgradeEncodedFrame(f id3v2Frame) (id3v2Frame, bool) {
+	if len(f.Data) == 0 {
+		return f, true
+	}
+	encoding := f.Data[0]
+	if encoding != id3v2EncodingUTF8 && encoding != id3v2EncodingUTF16BE {
+		return f, true
+	}
+	b := f.Data[1:]
+	data := []byte{id3v2EncodingUTF16}
+	appendString := func(s string, terminated bool) {
+		data = append(data, encodeID3v2Text(id3v2EncodingUTF16, s)...)
+		if terminated {
+			data = append(data, 0, 0)
+		}
+	}
+	switch f.ID {
+	case "TXXX":
+		desc, rest := splitID3v2String(encoding, b)
+		appendString(desc, true)
+		appendString(strings.Join(splitID3v2Strings(encoding, rest), "/"), false)
+	case "COMM", "USLT":
+		if len(b) < 3 {
+			return f, true
+		}
+		data = append(data, b[0:3]...)
+		desc, rest := splitID3v2String(encoding, b[3:])
+		appendString(desc, true)
+		appendString(decodeID3v2Text(encoding, rest), false)
+	case "APIC":
+		i := strings.IndexByte(string(b), 0)
+		if i < 0 || i+2 > len(b) {
+			return f, true
+		}
+		data = append(data, b[0:i+2]...) // MIME type and picture type
+		desc, rest := splitID3v2String(encoding, b[i+2:])
+		appendString(desc, true)
+		data = append(data, rest...)
+	case "WXXX":
+		desc, rest := splitID3v2String(encoding, b)
+		appendString(desc, true)
+		data = append(data, rest...)
+	default:
+		return f, false
+	}
+	f.Data = data
+	return f, true
+}
+
+// downgradeFile converts the ID3v2.4 tag of a file to ID3v2.3, prints
+// the information lost, and returns the status of the converted file.
+// With --dry-run it only prints them and returns the status unchanged.
+func downgradeFile(pathname string, status Mp3FileStatus) (Mp3FileStatus, error) {
+	change, err := PlanDowngrade(pathname, status)
+	if err != nil || change == nil {
+		return status, err
+	}
+	if *dryRunFlag {
+		fmt.Printf("%s: %s (dry run)\n", pathname, change)
+	} else {
+		if err := Downgrade(change, runJournal); err != nil {
+			return status, err
+		}
+		fmt.Printf("%s: %s\n", pathname, change)
+		if status, err = CheckMp3FileStatus(pathname); err != nil {
+			return status, err
+		}
+	}
+	for _, loss := range change.Losses {
+		fmt.Printf("\tlost: %s\n", loss)
+	}
+	return status, nil
+}
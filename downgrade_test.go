@@ -0,0 +1,106 @@
+// +build unittest
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dhowden/tag"
+)
+
+func TestDowngrade(t *testing.T) {
+	utf8Frame := func(id, text string) []byte {
+		return makeID3v24Frame(id, 0, append([]byte{id3v2EncodingUTF8}, text...))
+	}
+	id3v2Tag := makeID3v2TagWithFrames(4,
+		utf8Frame("TIT2", "日本の歌"),
+		utf8Frame("TPE1", "Artist A\x00Artist B"),
+		utf8Frame("TDRC", "2001-05-21T10:30:15"),
+		utf8Frame("TCON", "17\x00Shoegaze Revival"),
+		utf8Frame("TMOO", "Calm"),
+		utf8Frame("TSOP", "Artist A"),
+		utf8Frame("TIPL", "producer\x00Someone"),
+		makeID3v24Frame("COMM", 0, []byte("\x03eng\x00Grüße")))
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	audio := makeMpegFrames(4, 0xFF, 0xFB, 0x90, 0x44)
+	if err := os.WriteFile(path, append(id3v2Tag, audio...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := PlanDowngrade(path, status)
+	if err != nil || c == nil {
+		t.Fatalf("PlanDowngrade() = %v, %v", c, err)
+	}
+	expectedLosses := []string{
+		`TPE1 values joined with "/" into "Artist A/Artist B"`,
+		`seconds of TDRC "2001-05-21T10:30:15" dropped`,
+		`TMOO frame "Calm" dropped`,
+	}
+	if !reflect.DeepEqual(c.Losses, expectedLosses) {
+		t.Errorf("Losses = %q, expected %q", c.Losses, expectedLosses)
+	}
+	if err := Downgrade(c, nil); err != nil {
+		t.Fatalf("Downgrade() error = %v", err)
+	}
+
+	status, err = CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := status.ID3v2[0]; h.Version != 3 || h.TotalSize() != int64(len(id3v2Tag)) {
+		t.Errorf("ID3v2 tag = %v, expected ID3v2.3 of %d bytes", h, len(id3v2Tag))
+	}
+	f, _ := os.Open(path)
+	defer f.Close()
+	frames, err := readID3v2Tag(f, status.ID3v2[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]string)
+	for _, frame := range frames {
+		if frame.ID[0] == 'T' || frame.ID[0] == 'X' || frame.ID == "IPLS" {
+			if frame.Data[0] > id3v2EncodingUTF16 {
+				t.Errorf("%s frame has ID3v2.4 encoding %d", frame.ID, frame.Data[0])
+			}
+			got[frame.ID] = textFrameValues(frame)
+		}
+	}
+	expected := map[string][]string{
+		"TIT2": {"日本の歌"},
+		"TPE1": {"Artist A/Artist B"},
+		"TYER": {"2001"},
+		"TDAT": {"2105"},
+		"TIME": {"1030"},
+		"TCON": {"(17)Shoegaze Revival"},
+		"XSOP": {"Artist A"},
+		"IPLS": {"producer", "Someone"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("text frames = %q, expected %q", got, expected)
+	}
+	f.Seek(0, 0)
+	m, err := tag.ReadFrom(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Format() != tag.ID3v2_3 || m.Title() != "日本の歌" || m.Year() != 2001 || m.Comment() != "Grüße" {
+		t.Errorf("tag = %v %q %d %q", m.Format(), m.Title(), m.Year(), m.Comment())
+	}
+}
+
+func TestPlanDowngradeID3v23(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	if err := os.WriteFile(path, makeID3v23TagWithFrames("TIT2", "Title"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := CheckMp3FileStatus(path)
+	if c, err := PlanDowngrade(path, status); c != nil || err != nil {
+		t.Errorf("PlanDowngrade() = %v, %v, expected nothing to do", c, err)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// exportRecord is the row of a file in an exported inventory.  The
+// fields of the ID3v1 tag are named as in tagFieldNames, so that an
+// edited inventory can be imported back.
+type exportRecord struct {
+	Path     string   `json:"path"`
+	Kind     string   `json:"kind"`     // "both", "v1-only", "v2-only" or "untagged"
+	Tags     string   `json:"tags"`     // Tags in file order, e.g. "ID3v2.3.0, ID3v1.1"
+	Status   string   `json:"status"`   // "ok" or the policies failed, e.g. "fails v1"
+	Findings []string `json:"findings"` // Problems found in the tags and the audio
+
+	Title   string `json:"title"`
+	Artist  string `json:"artist"`
+	Album   string `json:"album"`
+	Year    string `json:"year"`
+	Comment string `json:"comment"`
+	Track   string `json:"track"`
+	Genre   string `json:"genre"`
+
+	V2Title       string `json:"v2_title"`
+	V2Artist      string `json:"v2_artist"`
+	V2Album       string `json:"v2_album"`
+	V2AlbumArtist string `json:"v2_album_artist"`
+	V2Year        string `json:"v2_year"`
+	V2Comment     string `json:"v2_comment"`
+	V2Track       string `json:"v2_track"`
+	V2Genre       string `json:"v2_genre"`
+
+	Audio       string  `json:"audio"` // e.g. "MPEG-1 Layer III"
+	ChannelMode string  `json:"channel_mode"`
+	SampleRate  int     `json:"sample_rate"` // Hz
+	Bitrate     int     `json:"bitrate"`     // Average bitrate in kbit/s
+	VBR         bool    `json:"vbr"`
+	Duration    float64 `json:"duration"` // Seconds
+}
+
+// exportColumns are the CSV columns of an inventory, named as the JSON
+// keys.
+var exportColumns = []string{
+	"path", "kind", "tags", "status", "findings",
+	"title", "artist", "album", "year", "comment", "track", "genre",
+	"v2_title", "v2_artist", "v2_album", "v2_album_artist", "v2_year", "v2_comment", "v2_track", "v2_genre",
+	"audio", "channel_mode", "sample_rate", "bitrate", "vbr", "duration",
+}
+
+// newExportRecord builds the row of a file from its status and the tags
+// read from it.
+func newExportRecord(pathname string, status Mp3FileStatus, failed policyList) (*exportRecord, error) {
+	r := &exportRecord{Path: pathname, Kind: status.Kind(), Status: "ok", Findings: []string{}}
+	if i := strings.Index(status.String(), ": "); i >= 0 {
+		r.Tags = status.String()[i+2:]
+	}
+	if len(failed) > 0 {
+		r.Status = "fails " + failed.String()
+	}
+	for _, f := range status.Findings {
+		r.Findings = append(r.Findings, f.String())
+	}
+	if status.ID3v1 != nil {
+		v := status.ID3v1.Fields()
+		r.Title, r.Artist, r.Album, r.Year, r.Comment = v.Title, v.Artist, v.Album, v.Year, v.Comment
+		if v.Track != 0 {
+			r.Track = strconv.Itoa(int(v.Track))
+		}
+		if int(v.Genre) < len(id3v1Genres) {
+			r.Genre = id3v1Genres[v.Genre]
+		} else if v.Genre != id3v1GenreNone {
+			r.Genre = strconv.Itoa(int(v.Genre))
+		}
+	}
+	if len(status.ID3v2) > 0 && !status.ID3v2[0].Appended {
+		if err := r.readID3v2(pathname); err != nil {
+			r.Findings = append(r.Findings, "ID3v2: "+err.Error())
+		}
+	}
+	if a := status.Audio; a != nil {
+		r.Audio = a.Version + " " + a.Layer
+		r.ChannelMode, r.SampleRate, r.Bitrate, r.VBR = a.ChannelMode, a.SampleRate, a.Bitrate, a.VBR
+		r.Duration = a.Duration.Seconds()
+	}
+	return r, nil
+}
+
+// readID3v2 fills the row with the key frames of the ID3v2 tag at the
+// start of a file.
+func (r *exportRecord) readID3v2(pathname string) error {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	m, err := tag.ReadID3v2Tags(f)
+	if err != nil {
+		return err
+	}
+	frames := m.Raw()
+	r.V2Title, r.V2Artist, r.V2Album, r.V2AlbumArtist = m.Title(), m.Artist(), m.Album(), m.AlbumArtist()
+	r.V2Year = id3v2FrameText(frames, "TYER", "TDRC", "TYE")
+	r.V2Track = id3v2FrameText(frames, "TRCK", "TRK")
+	r.V2Genre = m.Genre()
+	r.V2Comment = m.Comment()
+	return nil
+}
+
+// row returns the values of the CSV columns of the row.
+func (r *exportRecord) row() []string {
+	return []string{
+		r.Path, r.Kind, r.Tags, r.Status, strings.Join(r.Findings, "; "),
+		r.Title, r.Artist, r.Album, r.Year, r.Comment, r.Track, r.Genre,
+		r.V2Title, r.V2Artist, r.V2Album, r.V2AlbumArtist, r.V2Year, r.V2Comment, r.V2Track, r.V2Genre,
+		r.Audio, r.ChannelMode, itoaOrEmpty(r.SampleRate), itoaOrEmpty(r.Bitrate),
+		strconv.FormatBool(r.VBR), strconv.FormatFloat(r.Duration, 'f', 3, 64),
+	}
+}
+
+// columns returns the values of the row keyed by the CSV columns.
+func (r *exportRecord) columns() map[string]string {
+	m := make(map[string]string)
+	for i, value := range r.row() {
+		m[exportColumns[i]] = value
+	}
+	return m
+}
+
+// itoaOrEmpty formats a number, or returns "" for 0.
+func itoaOrEmpty(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// writeExport writes the rows of an inventory in the given format, which
+// is "csv" or "json".
+func writeExport(w io.Writer, format string, records []*exportRecord) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportColumns); err != nil {
+			return err
+		}
+		for _, r := range records {
+			if err := cw.Write(r.row()); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		if records == nil {
+			records = []*exportRecord{}
+		}
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(records)
+	default:
+		return fmt.Errorf("Unsupported format: %s", format)
+	}
+}
+
+// exportFile adds the row of a file to the inventory to export, with
+// the properties of its audio.
+func exportFile(pathname string, status Mp3FileStatus) error {
+	if err := analyseAudio(pathname, &status); err != nil {
+		return err
+	}
+	record, err := newExportRecord(pathname, status, checkPolicies(status))
+	if err != nil {
+		return err
+	}
+	exportRecords = append(exportRecords, record)
+	return nil
+}
+
+// exportInventory writes the rows of the files exported to a file, or
+// to the standard output if the file name is "-".
+func exportInventory(output string, format string, encoding string) error {
+	var f *os.File
+	if output == "-" {
+		f = os.Stdout
+	} else {
+		var err error
+		if f, err = os.Create(output); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	w, err := newWriter(f, encoding)
+	if err != nil {
+		return err
+	}
+	if err := writeExport(w, format, exportRecords); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if output != "-" {
+		return f.Close()
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	id3v2Tag := makeID3v23TagWithFrames("TIT2", "Long Title", "TPE1", "Artist", "TRCK", "3/12")
+	content := append(id3v2Tag, makeMpegFrames(10, 0xFF, 0xFB, 0x90, 0x44)...)
+	id3v1Tag := makeID3v1Tag(append(append([]byte("Comment"), make([]byte, 22)...), 5))
+	if err := os.WriteFile(path, append(content, id3v1Tag...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Audio, err = AnalyseMpegAudio(path, status); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newExportRecord(path, status, nil)
+	if err != nil {
+		t.Fatalf("newExportRecord() error = %v", err)
+	}
+	expected := []string{path, "both", "ID3v2.3.0 (flags 0x00, size 69), ID3v1.1", "ok", "",
+		"Title", "Artist", "Album", "2020", "Comment", "5", "Rock",
+		"Long Title", "Artist", "", "", "", "", "3/12", "",
+		"MPEG-1 Layer III", "joint stereo", "44100", "128", "false", "0.261"}
+	if got := r.row(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("row() = %q, expected %q", got, expected)
+	}
+
+	var b bytes.Buffer
+	if err := writeExport(&b, "csv", []*exportRecord{r}); err != nil {
+		t.Fatalf("writeExport() error = %v", err)
+	}
+	rows, err := csv.NewReader(&b).ReadAll()
+	if err != nil || len(rows) != 2 || !reflect.DeepEqual(rows[0], exportColumns) {
+		t.Errorf("CSV = %q, %v", rows, err)
+	}
+	b.Reset()
+	if err := writeExport(&b, "json", []*exportRecord{r}); err != nil {
+		t.Fatalf("writeExport() error = %v", err)
+	}
+	var records []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &records); err != nil || len(records) != 1 {
+		t.Fatalf("JSON = %s, %v", b.Bytes(), err)
+	}
+	for _, column := range exportColumns {
+		if _, ok := records[0][column]; !ok {
+			t.Errorf("JSON has no %s key", column)
+		}
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	var b bytes.Buffer
+	w, err := newWriter(&b, "ShiftJIS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("日本語 한국 ok"))
+	w.Close()
+	got, _ := japanese.ShiftJIS.NewDecoder().String(b.String())
+	if got != "日本語 ?? ok" {
+		t.Errorf("ShiftJIS writer wrote %q, expected %q", got, "日本語 ?? ok")
+	}
+	if _, err := newWriter(&b, "EBCDIC"); err == nil {
+		t.Error("newWriter() accepted an unsupported encoding")
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// fileOwner returns -1 for the user and group owning a file, which are
+// not preserved on this platform.
+func fileOwner(stat os.FileInfo) (int, int) {
+	return -1, -1
+}
+
+// fileLinks returns 1, since hard links are not counted on this
+// platform.
+func fileLinks(stat os.FileInfo) uint64 {
+	return 1
+}
+
+// chownFile does nothing on this platform.
+func chownFile(f *os.File, meta fileMeta) error {
+	return nil
+}
+
+// fileAccessTime returns the modification time of a file, since the
+// access time is not available on this platform.
+func fileAccessTime(stat os.FileInfo) time.Time {
+	return stat.ModTime()
+}
+
+// syncDir does nothing on this platform, where directories cannot be
+// synced.
+func syncDir(dir string) error {
+	return nil
+}
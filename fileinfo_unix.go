@@ -0,0 +1,67 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the user and group owning a file.
+func fileOwner(stat os.FileInfo) (int, int) {
+	if st, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return -1, -1
+}
+
+// fileLinks returns the number of hard links to a file.
+func fileLinks(stat os.FileInfo) uint64 {
+	if st, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
+
+// chownFile gives a file the ownership in the metadata, unless it has it
+// already.
+func chownFile(f *os.File, meta fileMeta) error {
+	if meta.UID < 0 {
+		return nil
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if uid, gid := fileOwner(stat); uid == meta.UID && gid == meta.GID {
+		return nil
+	}
+	return f.Chown(meta.UID, meta.GID)
+}
+
+// syncDir syncs a directory, making a rename in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
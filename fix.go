@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// id3v2FrameText returns the text of the first of the named frames
+// found in the frames read by tag.ReadID3v2Tags, which joins multiple
+// values of a frame without a separator.
+func id3v2FrameText(frames map[string]interface{}, names ...string) string {
+	for _, name := range names {
+		if s, ok := frames[name].(string); ok {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
+
+// id3v2FirstValues returns the first value of the first of each text
+// and comment frame read by readID3v2Texts, keyed by the frame ID.
+func id3v2FirstValues(texts []id3v2Text) map[string]string {
+	values := make(map[string]string)
+	for _, t := range texts {
+		if _, ok := values[t.ID]; !ok {
+			values[t.ID] = strings.TrimSpace(strings.SplitN(t.Text, "\x00", 2)[0])
+		}
+	}
+	return values
+}
+
+// id3v2Year extracts a four-digit year from the content of a TYER or
+// TDRC frame, such as "1999" or "1999-05-21T12:00".
+func id3v2Year(s string) string {
+	if len(s) >= 4 && strings.Trim(s[0:4], "0123456789") == "" {
+		return s[0:4]
+	}
+	return ""
+}
+
+// id3v2Track parses the content of a TRCK frame, such as "3" or "3/12",
+// into an ID3v1 track number.
+func id3v2Track(s string) byte {
+	n, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(s, "/", 2)[0]))
+	if err != nil || n < 0 || n > 255 {
+		return 0
+	}
+	return byte(n)
+}
+
+// ReadID3v1FieldsFromID3v2 builds the fields of an ID3v1.1 tag from the
+// title, artist, album, year, track, genre and comment frames of the
+// ID3v2 tag at the start of a file, taking the first of multiple values
+// of a frame, such as several artists.
+func ReadID3v1FieldsFromID3v2(pathname string, status Mp3FileStatus) (*ID3v1Fields, error) {
+	if len(status.ID3v2) > 0 && status.ID3v2[0].Version == 2 {
+		return readID3v1FieldsFromID3v22(pathname)
+	}
+	texts, err := readID3v2Texts(pathname, status)
+	if err != nil {
+		return nil, err
+	}
+	values := id3v2FirstValues(texts)
+	year := values["TYER"]
+	if year == "" {
+		year = values["TDRC"]
+	}
+	return &ID3v1Fields{
+		Title:   values["TIT2"],
+		Artist:  values["TPE1"],
+		Album:   values["TALB"],
+		Year:    id3v2Year(year),
+		Comment: values["COMM"],
+		Track:   id3v2Track(values["TRCK"]),
+		Genre:   id3v1GenreNumber(values["TCON"]),
+	}, nil
+}
+
+// readID3v1FieldsFromID3v22 builds the fields of an ID3v1.1 tag from the
+// frames of the ID3v2.2 tag at the start of a file, which has no
+// multiple values.
+func readID3v1FieldsFromID3v22(pathname string) (*ID3v1Fields, error) {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := tag.ReadID3v2Tags(f)
+	if err != nil {
+		return nil, id3Error{pathname, "Cannot read ID3v2 tag"}
+	}
+	frames := m.Raw()
+	v := &ID3v1Fields{
+		Title:  m.Title(),
+		Artist: m.Artist(),
+		Album:  m.Album(),
+		Year:   id3v2Year(id3v2FrameText(frames, "TYE")),
+		Track:  id3v2Track(id3v2FrameText(frames, "TRK")),
+		Genre:  id3v1GenreNumber(id3v2FrameText(frames, "TCO")),
+	}
+	if c, ok := frames["COM"].(*tag.Comm); ok {
+		v.Comment = strings.TrimSpace(c.Text)
+	}
+	return v, nil
+}
+
+// PlanMissingID3v1 plans an ID3v1.1 tag synthesised from the ID3v2 tag
+// of a file lacking an ID3v1 tag.
+func PlanMissingID3v1(pathname string, status Mp3FileStatus) (*TagChange, error) {
+	if len(status.ID3v2) == 0 || status.ID3v2[0].Appended {
+		return nil, id3Error{pathname, "Cannot fix a file without ID3v2 tag"}
+	}
+	v, err := ReadID3v1FieldsFromID3v2(pathname, status)
+	if err != nil {
+		return nil, err
+	}
+	return newTagChange(pathname, status, v), nil
+}
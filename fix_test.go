@@ -0,0 +1,172 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeID3v23Frame builds an ID3v2.3 frame with the given content.
+func makeID3v23Frame(id string, content []byte) []byte {
+	b := make([]byte, 10, 10+len(content))
+	copy(b, id)
+	binary.BigEndian.PutUint32(b[4:8], uint32(len(content)))
+	return append(b, content...)
+}
+
+// makeID3v23TagWithFrames builds an ID3v2.3 tag of ISO-8859-1 text
+// frames given as pairs of frame ID and text, where a COMM frame gets an
+// English language code and an empty description.
+func makeID3v23TagWithFrames(pairs ...string) []byte {
+	var body []byte
+	for i := 0; i+1 < len(pairs); i += 2 {
+		content := []byte{0}
+		if pairs[i] == "COMM" {
+			content = append(content, "eng\x00"...)
+		}
+		body = append(body, makeID3v23Frame(pairs[i], append(content, pairs[i+1]...))...)
+	}
+	body = append(body, make([]byte, 16)...) // Padding
+	tag := makeID3v2Tag(3, 0, len(body))
+	copy(tag[10:], body)
+	return tag
+}
+
+func TestFixMissingID3v1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	id3v2Tag := makeID3v23TagWithFrames(
+		"TIT2", "A Title Longer Than Thirty Characters",
+		"TPE1", "Artist",
+		"TALB", "Album",
+		"TYER", "1999",
+		"TRCK", "3/12",
+		"TCON", "(17)",
+		"COMM", "Comment")
+	frames := makeMpegFrames(10, 0xFF, 0xFB, 0x90, 0x44)
+	if err := os.WriteFile(path, append(id3v2Tag, frames...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change, err := PlanMissingID3v1(path, status)
+	if err != nil {
+		t.Fatalf("PlanMissingID3v1() error = %v", err)
+	}
+	if status, err = applyChange(change, status); err != nil {
+		t.Fatalf("applyChange() error = %v", err)
+	}
+	if !status.HasID3v1() || status.ID3v1.Revision != 1 {
+		t.Fatalf("status = %v, expected an ID3v1.1 tag", status)
+	}
+	expected := (&ID3v1Fields{"A Title Longer Than Thirty Characters", "Artist", "Album",
+		"1999", "Comment", 3, 17}).Encode()
+	if !bytes.Equal(status.ID3v1.Raw[:], expected) {
+		t.Errorf("ID3v1 tag = %q, expected %q", status.ID3v1.Raw, expected)
+	}
+	if got := string(status.ID3v1.Raw[3:33]); got != "A Title Longer Than Thirty Cha" {
+		t.Errorf("title = %q, expected it truncated to 30 bytes", got)
+	}
+}
+
+func TestReadID3v1FieldsFromID3v24(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	id3v2Tag := makeID3v2TagWithFrames(4,
+		makeID3v24Frame("TPE1", 0, []byte("\x00First\x00Second")),
+		makeID3v24Frame("TDRC", 0, []byte("\x002011-05-03")),
+		makeID3v24Frame("TCON", 0, []byte("\x0017\x00Indie")))
+	if err := os.WriteFile(path, append(id3v2Tag, makeMpegFrames(4, 0xFF, 0xFB, 0x90, 0x44)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ReadID3v1FieldsFromID3v2(path, status)
+	if err != nil {
+		t.Fatalf("ReadID3v1FieldsFromID3v2() error = %v", err)
+	}
+	if v.Artist != "First" || v.Year != "2011" || v.Genre != 17 {
+		t.Errorf("fields = %+v, expected the first values", v)
+	}
+}
+
+func TestFixMissingID3v1WithoutID3v2(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	if err := os.WriteFile(path, makeMpegFrames(10, 0xFF, 0xFB, 0x90, 0x44), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := CheckMp3FileStatus(path)
+	if _, err := PlanMissingID3v1(path, status); err == nil {
+		t.Error("PlanMissingID3v1() succeeded without an ID3v2 tag")
+	}
+}
+
+func TestID3v2ToID3v1Conversions(t *testing.T) {
+	for _, tt := range []struct {
+		genre    string
+		expected byte
+	}{
+		{"Rock", 17},
+		{"rock", 17},
+		{"(17)", 17},
+		{"(17)Rock", 17},
+		{"52", 52},
+		{"Psybient", 191},
+		{"(RX)", id3v1GenreNone},
+		{"Unknown Genre", id3v1GenreNone},
+		{"", id3v1GenreNone},
+	} {
+		if got := id3v1GenreNumber(tt.genre); got != tt.expected {
+			t.Errorf("id3v1GenreNumber(%q) = %d, expected %d", tt.genre, got, tt.expected)
+		}
+	}
+	for _, tt := range []struct {
+		track    string
+		expected byte
+	}{
+		{"3", 3}, {"3/12", 3}, {" 07 / 12", 7}, {"300", 0}, {"", 0}, {"A1", 0},
+	} {
+		if got := id3v2Track(tt.track); got != tt.expected {
+			t.Errorf("id3v2Track(%q) = %d, expected %d", tt.track, got, tt.expected)
+		}
+	}
+	for _, tt := range []struct {
+		date     string
+		expected string
+	}{
+		{"1999", "1999"}, {"2011-05-03T10:00", "2011"}, {"99", ""}, {"", ""},
+	} {
+		if got := id3v2Year(tt.date); got != tt.expected {
+			t.Errorf("id3v2Year(%q) = %q, expected %q", tt.date, got, tt.expected)
+		}
+	}
+}
+
+func TestID3v1FieldsEncode(t *testing.T) {
+	b := (&ID3v1Fields{Title: "Café 日本", Comment: "No track", Genre: id3v1GenreNone}).Encode()
+	tag, ok := parseID3v1Tag(b)
+	if !ok {
+		t.Fatal("parseID3v1Tag() failed")
+	}
+	if got := string(b[3:11]); got != "Caf\xe9 ??\x00" {
+		t.Errorf("title = %q, expected ISO-8859-1 with replacement", got)
+	}
+	if tag.Revision != 1 || tag.Track() != 0 || tag.Genre() != id3v1GenreNone {
+		t.Errorf("tag = %v track %d genre %d", tag, tag.Track(), tag.Genre())
+	}
+
+	comment := "A comment of thirty characters"
+	b = (&ID3v1Fields{Title: "Title", Comment: comment, Genre: id3v1GenreNone}).Encode()
+	if tag, ok = parseID3v1Tag(b); !ok {
+		t.Fatal("parseID3v1Tag() failed")
+	}
+	if tag.Revision != 0 || tag.Fields().Comment != comment {
+		t.Errorf("comment = %q in ID3v1.%d tag, expected %q in ID3v1.0 tag", tag.Fields().Comment, tag.Revision, comment)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// pathPattern extracts the fields of a tag from the path of a file,
+// such as "%artist%/%album% (%year%)/%track% - %title%".
+type pathPattern struct {
+	Expr   string
+	re     *regexp.Regexp
+	fields []string // Fields in the order of the groups of re
+	depth  int      // Number of path components the pattern spans
+}
+
+// pathPatternFields maps the placeholders of a pattern to the regular
+// expressions their values match.  Values never span path components.
+var pathPatternFields = map[string]string{
+	"title":   `[^/]+?`,
+	"artist":  `[^/]+?`,
+	"album":   `[^/]+?`,
+	"year":    `[0-9]{4}`,
+	"comment": `[^/]+?`,
+	"track":   `[0-9]{1,3}`,
+	"genre":   `[^/]+?`,
+	"ignore":  `[^/]*?`,
+}
+
+var pathPlaceholder = regexp.MustCompile(`%([a-z]+)%`)
+
+// parsePathPattern parses a pattern of literal text and placeholders of
+// fields enclosed in '%', where "/" separates directories and the
+// extension of the file name is left out.  The placeholder "%ignore%"
+// matches text not used for any field.
+func parsePathPattern(expr string) (*pathPattern, error) {
+	p := &pathPattern{Expr: expr, depth: strings.Count(expr, "/") + 1}
+	var re strings.Builder
+	re.WriteString("^")
+	last := 0
+	for _, m := range pathPlaceholder.FindAllStringSubmatchIndex(expr, -1) {
+		name := expr[m[2]:m[3]]
+		sub, ok := pathPatternFields[name]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported field in pattern: %%%s%%", name)
+		}
+		re.WriteString(regexp.QuoteMeta(expr[last:m[0]]))
+		if name == "ignore" {
+			re.WriteString(sub)
+		} else {
+			re.WriteString("(" + sub + ")")
+			p.fields = append(p.fields, name)
+		}
+		last = m[1]
+	}
+	re.WriteString(regexp.QuoteMeta(expr[last:]))
+	re.WriteString("$")
+	if len(p.fields) == 0 {
+		return nil, fmt.Errorf("No field in pattern: %s", expr)
+	}
+	var err error
+	if p.re, err = regexp.Compile(re.String()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Match extracts the values of the fields from the last components of a
+// path, or returns false if the path does not match the pattern.
+func (p *pathPattern) Match(pathname string) (map[string]string, bool) {
+	path := filepath.ToSlash(strings.TrimSuffix(pathname, filepath.Ext(pathname)))
+	parts := strings.Split(path, "/")
+	if len(parts) < p.depth {
+		return nil, false
+	}
+	m := p.re.FindStringSubmatch(strings.Join(parts[len(parts)-p.depth:], "/"))
+	if m == nil {
+		return nil, false
+	}
+	values := make(map[string]string)
+	for i, name := range p.fields {
+		value := strings.TrimSpace(m[i+1])
+		if old, ok := values[name]; ok && old != value {
+			return nil, false
+		}
+		values[name] = value
+	}
+	return values, true
+}
+
+// String returns the pattern, making pathPattern a flag.Value.
+func (p *pathPattern) String() string {
+	if p == nil {
+		return ""
+	}
+	return p.Expr
+}
+
+// Set parses a pattern given by a flag.
+func (p *pathPattern) Set(expr string) error {
+	q, err := parsePathPattern(expr)
+	if err != nil {
+		return err
+	}
+	*p = *q
+	return nil
+}
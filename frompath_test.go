@@ -0,0 +1,124 @@
+// +build unittest
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dhowden/tag"
+)
+
+func TestParsePathPattern(t *testing.T) {
+	for _, expr := range []string{"%artist%/%name%", "Artist - Title", ""} {
+		if _, err := parsePathPattern(expr); err == nil {
+			t.Errorf("parsePathPattern(%q) succeeded, expected an error", expr)
+		}
+	}
+}
+
+func TestPathPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected map[string]string
+	}{
+		{"%artist%/%album% (%year%)/%track% - %title%",
+			"/music/Some Artist/Great Album (1999)/03 - Song Title.mp3",
+			map[string]string{"artist": "Some Artist", "album": "Great Album", "year": "1999",
+				"track": "03", "title": "Song Title"}},
+		{"%artist%/%album% (%year%)/%track% - %title%",
+			"Artist/Album (Deluxe) (2001)/1 - A - B.MP3",
+			map[string]string{"artist": "Artist", "album": "Album (Deluxe)", "year": "2001",
+				"track": "1", "title": "A - B"}},
+		{"%artist%/%album% (%year%)/%track% - %title%",
+			"Artist/Album/01 - Title.mp3", nil},
+		{"%artist%/%album% (%year%)/%track% - %title%", "01 - Title.mp3", nil},
+		{"%ignore%/%artist% - %title%", "inbox/Artist - Title.mp3",
+			map[string]string{"artist": "Artist", "title": "Title"}},
+		{"%artist% - %title% [%artist%]", "A - B [C].mp3", nil},
+	}
+	for _, tt := range tests {
+		p, err := parsePathPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("parsePathPattern(%q) error = %v", tt.pattern, err)
+		}
+		got, ok := p.Match(filepath.FromSlash(tt.path))
+		if ok != (tt.expected != nil) || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Match(%q) = %v, %v, expected %v", tt.path, got, ok, tt.expected)
+		}
+	}
+}
+
+func TestID3v1FieldsWith(t *testing.T) {
+	v := ID3v1Fields{Title: "Old", Artist: "Artist", Genre: id3v1GenreNone}
+	got := v.With(map[string]string{"title": "New", "track": "07", "genre": "Rock"})
+	expected := &ID3v1Fields{Title: "New", Artist: "Artist", Track: 7, Genre: 17}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("With() = %+v, expected %+v", got, expected)
+	}
+	if v.Title != "Old" {
+		t.Error("With() modified the receiver")
+	}
+}
+
+func TestPlanID3v2Change(t *testing.T) {
+	values := map[string]string{"title": "日本の歌", "artist": "Artist", "track": "03",
+		"genre": "Rock", "comment": "Comment"}
+	audio := makeMpegFrames(4, 0xFF, 0xFB, 0x90, 0x44)
+	tests := []struct {
+		name    string
+		id3v2   []byte
+		what    string
+		wantErr bool
+	}{
+		{"No tag", nil, "ID3v2.3 tag added", false},
+		{"ID3v2.3 tag", makeID3v23TagWithFrames("TIT2", "Old", "TPE2", "Band", "COMM", "Old"),
+			"ID3v2.3 tag updated", false},
+		{"ID3v2.4 tag", makeID3v2TagWithFrames(4, makeID3v24Frame("TIT2", 0, []byte("\x03Old"))), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.mp3")
+			if err := os.WriteFile(path, append(tt.id3v2, audio...), 0644); err != nil {
+				t.Fatal(err)
+			}
+			status, err := CheckMp3FileStatus(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := PlanID3v2Change(path, status, values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PlanID3v2Change() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if c.What != tt.what || !c.Changed() {
+				t.Errorf("change = %s, changed %v, expected %s", c.What, c.Changed(), tt.what)
+			}
+			if err := writeID3v2Change(c, nil); err != nil {
+				t.Fatalf("writeID3v2Change() error = %v", err)
+			}
+			f, _ := os.Open(path)
+			defer f.Close()
+			m, err := tag.ReadFrom(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if track, _ := m.Track(); m.Title() != "日本の歌" || m.Artist() != "Artist" ||
+				track != 3 || m.Genre() != "Rock" || m.Comment() != "Comment" {
+				t.Errorf("tag = %q %q %d %q %q", m.Title(), m.Artist(), track, m.Genre(), m.Comment())
+			}
+			if tt.id3v2 != nil && m.AlbumArtist() != "Band" {
+				t.Errorf("TPE2 = %q, expected it kept", m.AlbumArtist())
+			}
+			status, _ = CheckMp3FileStatus(path)
+			if status.AudioStart+int64(len(audio)) != status.Size {
+				t.Errorf("audio moved: starts at %d in %d bytes", status.AudioStart, status.Size)
+			}
+		})
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"strings"
 
 	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/runes"
 	"golang.org/x/text/transform"
 )
 
@@ -36,14 +37,136 @@ var versionFlag = flag.Bool("V", false, "Print the version number.")
 var licenceFlag = flag.Bool("L", false, "Print the licencing notice.")
 var filesFlag = flag.String("files", "", "Provides a list of files to process.")
 var encodingFlag = flag.String("encoding", "UTF-8",
-	"Encoding of a file that -files flag provides.")
+	"Encoding of a file that -files flag provides, or of an inventory to import.")
 var dirFlag = flag.String("dir", "", "Specifies the directory to test files in.")
+var requireFlag policyList
+var verboseFlag = flag.Bool("verbose", false,
+	"Reports the tags found in every file.")
+var audioFlag = flag.Bool("audio", false,
+	"Reports the MPEG audio properties of every file.  Implies --verbose.")
+var integrityFlag = flag.Bool("integrity", false,
+	"Reports files whose MPEG audio stream is truncated or corrupted.")
+var fixFlag = flag.Bool("fix", false,
+	"Appends an ID3v1 tag built from the ID3v2 tag to files lacking one.")
+var downgradeFlag = flag.Bool("downgrade", false,
+	"Rewrites ID3v2.4 tags as ID3v2.3 tags, reporting what is lost.")
+
+// setFlags are the options of the set command, keyed by the names in
+// id3v2FieldNames.
+var setFlags = map[string]*string{
+	"title":   flag.String("title", "", "Sets the title with the set command."),
+	"artist":  flag.String("artist", "", "Sets the artist with the set command."),
+	"album":   flag.String("album", "", "Sets the album with the set command."),
+	"year":    flag.String("year", "", "Sets the year with the set command."),
+	"comment": flag.String("comment", "", "Sets the comment with the set command."),
+	"track":   flag.String("track", "", "Sets the track number with the set command."),
+	"genre":   flag.String("genre", "", "Sets the genre by name or number with the set command."),
+	"album_artist": flag.String("album-artist", "",
+		"Sets the album artist of the ID3v2 tag with the set command and --write-v2."),
+}
+var codepageFlag = flag.String("codepage", "latin1",
+	"Codepage of ID3v1 text: latin1, cp932 (or shiftjis), cp1251, cp1252, euc-kr\n"+
+		"or gbk.")
+var transliterateFlag = flag.Bool("transliterate", false,
+	"Transliterates kana, Cyrillic and Greek to Latin letters and strips accents\n"+
+		"from the text of ID3v1 tags written.")
+var dictionaryFlag = flag.String("dictionary", "",
+	"Specifies a file of text to replace before --transliterate, with a tab\n"+
+		"between the text and its replacement on each line.")
+var mojibakeFlag = flag.String("mojibake", "",
+	"Reports text fields reading more plausibly in one of the codepages given,\n"+
+		"e.g. utf-8,cp932,cp1251, in the order of preference.")
+var repairMojibakeFlag = flag.Bool("repair-mojibake", false,
+	"Rewrites the text fields found by --mojibake as read in the likely codepage.")
+var charsetFlag = flag.String("charset", "",
+	"Reports the characters of tags a device reading the given codepage cannot\n"+
+		"show, such as cp932 or euc-kr, including those of vendor extensions.")
+var fixNormalizeFlag = flag.Bool("fix-normalize", false,
+	"Rewrites the text fields found by --normalize with their normalized text.")
+var fromPathFlag pathPattern
+var shortenFlag = shortenRules{}
+var normalizeFlag = normalizeRules{}
+var writeV2Flag = flag.Bool("write-v2", false,
+	"Writes the fields set by --from-path or the set command to the ID3v2 tag\n"+
+		"as well as ID3v1.")
+var dryRunFlag = flag.Bool("dry-run", false,
+	"Prints the changes to be made to every file without writing them.")
+var formatFlag = flag.String("format", "csv",
+	"Selects the format the export command writes and the import command reads:\n"+
+		"csv or json.")
+var outputFlag = flag.String("output", "-",
+	"Specifies the file the export command writes to, or - for the standard output.")
+var outputEncodingFlag = flag.String("output-encoding", "UTF-8",
+	"Encoding of the CSV file the export command writes: UTF-8 or ShiftJIS.")
+var journalFlag = flag.String("journal", defaultJournalDir(),
+	"Specifies the directory recording the writes to undo.")
+var noJournalFlag = flag.Bool("no-journal", false,
+	"Writes files without recording the writes in the journal, so that they cannot be undone.")
+var stripTagsFlag = stripSelection{}
+var sniffFlag = flag.Bool("sniff", false,
+	"Identifies MP3 files by their content as well as by the .mp3 extension.")
+var validateFlag = flag.Bool("validate", false,
+	"Reports files whose tags have problems, with a list of findings.")
+
+var defaultPolicies = policyList{{Expr: "v1", terms: []tagRequirement{{1, 0}}}}
+
+// command is the command given as the first argument, or "" to check
+// files.
+var command string
+
+// setValues are the values of the fields the set command sets, keyed
+// by the names in tagFieldNames.
+var setValues = make(map[string]string)
+
+// exportRecords are the rows of the files the export command exports.
+var exportRecords []*exportRecord
+
+// titles shortens the fields of ID3v1 tags written with --shorten, or
+// is nil without it.
+var titles *titleShortener
+
+// transliteration transliterates the fields of ID3v1 tags written with
+// --transliterate, or is nil without it.
+var transliteration *transliterator
+
+// mojibakeCandidates are the codepages --mojibake tries on text fields.
+var mojibakeCandidates []*codepage
+
+// charsetTarget is the codepage of the device --charset checks tags
+// against, or nil without it.
+var charsetTarget *codepage
+
+// normalization are the rules --normalize checks text fields by, or
+// nil without it.
+var normalization normalizeRules
+
+// runJournal records the writes of this run, or is nil with --no-journal.
+var runJournal *journal
 
 type id3Error struct {
 	Path string
 	What string
 }
 
+func init() {
+	flag.Var(&requireFlag, "require",
+		"Selects the tags a file must have, e.g. v1, v1.1, v2.3, v2, any or v1+v2.\n"+
+			"May be repeated to test several policies at once (default v1).")
+	flag.Var(&fromPathFlag, "from-path",
+		"Sets the fields of the ID3v1 tag from the path of every file by a pattern,\n"+
+			"e.g. '%artist%/%album% (%year%)/%track% - %title%'.")
+	flag.Var(shortenFlag, "shorten",
+		"Shortens text too long for ID3v1 fields by rules: parens, feat, words, all or\n"+
+			"none, separated with commas, keeping the titles of an album distinct.")
+	flag.Var(normalizeFlag, "normalize",
+		"Reports text fields not normalized by rules: nfc, nfkc, kana, alnum, space,\n"+
+			"all or none, separated with commas.")
+	stripTagsFlag.Set("all")
+	flag.Var(stripTagsFlag, "tags",
+		"Selects the tags the strip command removes: v1, v2, ape, lyrics3 or all,\n"+
+			"separated with commas.")
+}
+
 //go:generate go run tools/files2go.go -o notice.go NOTICE.txt
 
 func (e id3Error) Error() string {
@@ -51,7 +174,26 @@ func (e id3Error) Error() string {
 }
 
 func main() {
-	parseFlagsAndExit()
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "undo":
+			os.Exit(undoCommand(args[1:]))
+		case "strip", "set", "export", "import":
+			command, args = args[0], args[1:]
+		}
+	}
+	parseFlagsAndExit(args)
+	if !*noJournalFlag {
+		runJournal = newJournal(*journalFlag)
+	}
+	if command == "import" {
+		status := importCommand(flag.Arg(0))
+		if err := runJournal.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		os.Exit(status)
+	}
 
 	var files []string
 	var err error
@@ -68,9 +210,23 @@ func main() {
 			os.Exit(1)
 		}
 	} else {
-		files = os.Args[len(os.Args)-flag.NArg() : len(os.Args)]
+		files = flag.Args()
 	}
 	nSuccess, _ := getFileStatuses(files)
+	if command == "export" {
+		if err := exportInventory(*outputFlag, *formatFlag, *outputEncodingFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	}
+	if err := runJournal.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+	if len(requireFlag) > 1 {
+		for _, p := range requireFlag {
+			fmt.Fprintf(os.Stderr, "%s: %d file(s) failed\n", p.Expr, p.Failures)
+		}
+	}
 	if nSuccess == 0 {
 		os.Exit(1)
 	} else {
@@ -137,15 +293,19 @@ func readdir(dirname string) (dirs []string, files []string, err error) {
 		} else {
 			if strings.EqualFold(filepath.Ext(path), ".mp3") == true {
 				files = append(files, path)
+			} else if *sniffFlag {
+				if container, err4 := sniffFile(path); err4 == nil && container == containerMP3 {
+					files = append(files, path)
+				}
 			}
 		}
 	}
 	return dirs, files, nil
 }
 
-func parseFlagsAndExit() {
+func parseFlagsAndExit(args []string) {
 	flag.Usage = printUsage
-	flag.Parse()
+	flag.CommandLine.Parse(args)
 
 	if *versionFlag {
 		fmt.Println("Version:", appVersion)
@@ -162,6 +322,112 @@ func parseFlagsAndExit() {
 		os.Exit(2)
 	}
 
+	if cp, ok := lookupCodepage(*codepageFlag); ok {
+		id3v1Codepage = cp
+	} else {
+		fmt.Fprintf(os.Stderr, "Unsupported codepage: %s\n", *codepageFlag)
+		os.Exit(2)
+	}
+
+	if len(*charsetFlag) > 0 {
+		var ok bool
+		if charsetTarget, ok = lookupCodepage(*charsetFlag); !ok {
+			fmt.Fprintf(os.Stderr, "Unsupported codepage: %s\n", *charsetFlag)
+			os.Exit(2)
+		}
+	}
+
+	if len(*mojibakeFlag) > 0 {
+		var err error
+		if mojibakeCandidates, err = parseMojibakeCandidates(*mojibakeFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(2)
+		}
+	} else if *repairMojibakeFlag {
+		fmt.Fprintf(os.Stderr, "You can specify --repair-mojibake only with --mojibake\n\n")
+		printUsage()
+		os.Exit(2)
+	}
+
+	if *audioFlag {
+		*verboseFlag = true
+	}
+	if command == "export" {
+		// The columns of the inventory include the properties of the audio
+		*audioFlag = true
+	}
+
+	if len(*dictionaryFlag) > 0 && !*transliterateFlag {
+		fmt.Fprintf(os.Stderr, "You can specify --dictionary only with --transliterate\n\n")
+		printUsage()
+		os.Exit(2)
+	}
+	if *transliterateFlag {
+		var err error
+		if transliteration, err = loadTransliterator(*dictionaryFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(2)
+		}
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		name := strings.Replace(f.Name, "-", "_", -1)
+		if value, ok := setFlags[name]; ok {
+			setValues[name] = *value
+			if transliteration != nil && name != "track" && name != "genre" && name != "album_artist" {
+				setValues[name] = transliteration.Transliterate(*value)
+			}
+		}
+		if f.Name == "shorten" {
+			titles = newTitleShortener(shortenFlag)
+		}
+		if f.Name == "normalize" {
+			normalization = normalizeFlag
+		}
+	})
+	if normalization == nil && *fixNormalizeFlag {
+		fmt.Fprintf(os.Stderr, "You can specify --fix-normalize only with --normalize\n\n")
+		printUsage()
+		os.Exit(2)
+	}
+	if command == "set" && len(setValues) == 0 {
+		fmt.Fprintf(os.Stderr, "You must specify at least one field to set\n\n")
+		printUsage()
+		os.Exit(2)
+	}
+	if _, ok := setValues["album_artist"]; ok && !*writeV2Flag {
+		fmt.Fprintf(os.Stderr, "You can specify --album-artist only with --write-v2\n\n")
+		printUsage()
+		os.Exit(2)
+	}
+	if command != "set" && len(setValues) > 0 {
+		fmt.Fprintf(os.Stderr, "You can specify fields to set only with the set command\n\n")
+		printUsage()
+		os.Exit(2)
+	}
+	if err := validateEncodingFlag(*outputEncodingFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	if *formatFlag != "csv" && *formatFlag != "json" {
+		fmt.Fprintf(os.Stderr, "Unsupported format: %s\n", *formatFlag)
+		os.Exit(2)
+	}
+	if *formatFlag == "json" && *outputEncodingFlag != "UTF-8" {
+		fmt.Fprintf(os.Stderr, "JSON is always written in UTF-8\n")
+		os.Exit(2)
+	}
+	if err := checkID3v1Values(setValues, titles != nil); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	if command == "import" && (flag.NArg() != 1 || len(*filesFlag) > 0 || len(*dirFlag) > 0) {
+		fmt.Fprintf(os.Stderr, "You must specify one inventory to import\n\n")
+		printUsage()
+		os.Exit(2)
+	}
+
 	if len(*filesFlag) > 0 && len(*dirFlag) > 0 {
 		fmt.Fprintf(os.Stderr, "You cannot specify --files and --dir at the same time\n\n")
 		printUsage()
@@ -191,8 +457,15 @@ func parseFlagsAndExit() {
 func printUsage() {
 	executable := filepath.Base(os.Args[0])
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", executable)
-	fmt.Fprintln(os.Stderr, executable, "mp3file [...]")
+	fmt.Fprintln(os.Stderr, executable, "[--require=<policy>] [--sniff] [--validate] [--verbose] [--audio] [--integrity] [--downgrade] [--mojibake=<codepages> [--repair-mojibake]] [--normalize=<rules> [--fix-normalize]] [--charset=<codepage>] [--from-path=<pattern> [--write-v2]] [--fix] [--transliterate [--dictionary=<file>]] [--shorten=<rules>] [--codepage=<codepage>] [--dry-run] mp3file [...]")
 	fmt.Fprintln(os.Stderr, executable, "--files=<list> --encoding=<encoding>")
+	fmt.Fprintln(os.Stderr, executable, "set [--title=<title>] [--artist=<artist>] [--album=<album>] [--year=<year>]")
+	fmt.Fprintln(os.Stderr, strings.Repeat(" ", len(executable)+4),
+		"[--comment=<comment>] [--track=<track>] [--genre=<genre>] [--write-v2 [--album-artist=<artist>]] [--dry-run] mp3file [...]")
+	fmt.Fprintln(os.Stderr, executable, "export [--format=csv|json] [--output=<file>] [--output-encoding=<encoding>] mp3file [...]")
+	fmt.Fprintln(os.Stderr, executable, "import [--format=csv|json] [--encoding=<encoding>] [--dry-run] inventory")
+	fmt.Fprintln(os.Stderr, executable, "strip [--tags=<tags>] [--dry-run] mp3file [...]")
+	fmt.Fprintln(os.Stderr, executable, "undo [--journal=<dir>] [<run> [mp3file ...]]")
 	fmt.Fprintln(os.Stderr, executable, "-H | -L | -V")
 	flag.PrintDefaults()
 }
@@ -223,24 +496,277 @@ func getFileStatuses(pathnames []string) (nSuccess int, nError int) {
 }
 
 func getFileStatus(pathname string) error {
-	switch strings.ToLower(filepath.Ext(pathname)) {
-	case ".mp3":
-		result, err := CheckMp3FileStatus(pathname)
-		if err != nil {
-			return err
+	mp3Ext := strings.EqualFold(filepath.Ext(pathname), ".mp3")
+	if !mp3Ext && !*sniffFlag {
+		return id3Error{
+			pathname,
+			"Unsupported file type",
 		}
-		if !result {
-			fmt.Println(pathname)
+	}
+	container, err1 := sniffFile(pathname)
+	if err1 != nil {
+		return err1
+	}
+	switch {
+	case container == containerMP3:
+	case mp3Ext && container != containerUnknown:
+		return id3Error{
+			pathname,
+			fmt.Sprintf("Mislabelled %s file", container),
 		}
-	default:
+	case !mp3Ext:
 		return id3Error{
 			pathname,
 			"Unsupported file type",
 		}
 	}
+	status, err2 := CheckMp3FileStatus(pathname)
+	if err2 != nil {
+		return err2
+	}
+	switch command {
+	case "strip":
+		return stripFile(PlanStrip(pathname, status, stripTagsFlag))
+	case "set":
+		if err := checkID3v1ValuesFor(setValues, status, titles != nil); err != nil {
+			return id3Error{pathname, err.Error()}
+		}
+		_, err := setFields(pathname, status, setValues)
+		return err
+	case "export":
+		return exportFile(pathname, status)
+	}
+	if *downgradeFlag {
+		if status, err2 = downgradeFile(pathname, status); err2 != nil {
+			return err2
+		}
+	}
+	if mojibakeCandidates != nil {
+		if status, err2 = checkMojibake(pathname, status); err2 != nil {
+			return err2
+		}
+	}
+	if normalization != nil {
+		if status, err2 = checkNormalization(pathname, status); err2 != nil {
+			return err2
+		}
+	}
+	if fromPathFlag.re != nil {
+		values, ok := fromPathFlag.Match(pathname)
+		if !ok {
+			return id3Error{pathname, "Path does not match the --from-path pattern"}
+		}
+		if status, err2 = setFields(pathname, status, values); err2 != nil {
+			return err2
+		}
+	}
+	if *fixFlag && !status.HasID3v1() {
+		change, err3 := PlanMissingID3v1(pathname, status)
+		if err3 != nil {
+			return err3
+		}
+		if status, err3 = applyChange(prepareChange(change), status); err3 != nil {
+			return err3
+		}
+	}
+	if charsetTarget != nil {
+		if err3 := checkCharset(pathname, status); err3 != nil {
+			return err3
+		}
+	}
+	if err3 := analyseAudio(pathname, &status); err3 != nil {
+		return err3
+	}
+	printFileStatus(pathname, status, checkPolicies(status))
 	return nil
 }
 
+// applyChange writes a planned change to a file and returns the status
+// of the changed file.  With --dry-run it prints the change instead,
+// field by field, and returns the status unchanged.
+func applyChange(change *TagChange, status Mp3FileStatus) (Mp3FileStatus, error) {
+	if *dryRunFlag {
+		printChange(change)
+		return status, nil
+	}
+	if !change.Changed() {
+		return status, nil
+	}
+	if err := writeTagChange(change, runJournal); err != nil {
+		return status, err
+	}
+	fmt.Printf("%s: %s\n", change.Path, change.What)
+	for _, f := range change.Unrepresentable() {
+		fmt.Printf("\t%s\n", f)
+	}
+	return CheckMp3FileStatus(change.Path)
+}
+
+// applyID3v2Change writes a planned change to the ID3v2 tag of a file
+// and returns the status of the changed file.  With --dry-run it prints
+// the change instead, frame by frame, and returns the status unchanged.
+func applyID3v2Change(change *ID3v2Change, status Mp3FileStatus) (Mp3FileStatus, error) {
+	if *dryRunFlag {
+		if !change.Changed() {
+			fmt.Printf("%s: no change to ID3v2 tag\n", change.Path)
+			return status, nil
+		}
+		fmt.Printf("%s: %s (dry run)\n", change.Path, change.What)
+		for _, f := range change.Fields {
+			fmt.Printf("\t%s\n", f)
+		}
+		return status, nil
+	}
+	if !change.Changed() {
+		return status, nil
+	}
+	if err := writeID3v2Change(change, runJournal); err != nil {
+		return status, err
+	}
+	fmt.Printf("%s: %s\n", change.Path, change.What)
+	return CheckMp3FileStatus(change.Path)
+}
+
+// setFields writes the values of fields, keyed by the names in
+// tagFieldNames, to the ID3v1 tag of a file, and to its ID3v2 tag with
+// --write-v2.  It returns the status of the changed file.
+func setFields(pathname string, status Mp3FileStatus, values map[string]string) (Mp3FileStatus, error) {
+	if *writeV2Flag {
+		change, err := PlanID3v2Change(pathname, status, values)
+		if err != nil {
+			return status, err
+		}
+		if status, err = applyID3v2Change(change, status); err != nil {
+			return status, err
+		}
+	}
+	fields := ID3v1Fields{Genre: id3v1GenreNone}
+	if status.ID3v1 != nil {
+		fields = *status.ID3v1.Fields()
+	}
+	return applyChange(prepareChange(newTagChange(pathname, status, fields.With(values))), status)
+}
+
+// prepareChange transliterates the fields of a planned change with
+// --transliterate, then shortens them with --shorten.
+func prepareChange(change *TagChange) *TagChange {
+	if transliteration != nil {
+		change.New = transliteration.Fields(*change.New)
+	}
+	if titles != nil {
+		change.Shorten(titles)
+	}
+	return change
+}
+
+// applyTagChanges writes planned changes to the ID3v2 and the ID3v1 tag
+// of a file, either of which may be nil, and returns the status of the
+// changed file.
+func applyTagChanges(v1Change *TagChange, v2Change *ID3v2Change, status Mp3FileStatus) (Mp3FileStatus, error) {
+	var err error
+	if v2Change != nil {
+		if status, err = applyID3v2Change(v2Change, status); err != nil {
+			return status, err
+		}
+	}
+	if v1Change != nil {
+		// The ID3v1 tag moves when the ID3v2 tag grows
+		change := newTagChange(v1Change.Path, status, v1Change.New)
+		change.What = v1Change.What
+		return applyChange(change, status)
+	}
+	return status, nil
+}
+
+// printChange prints the current and proposed value of every field of
+// a planned change.
+func printChange(change *TagChange) {
+	if !change.Changed() {
+		fmt.Printf("%s: no change\n", change.Path)
+		return
+	}
+	fmt.Printf("%s: %s (dry run)\n", change.Path, change.What)
+	for _, f := range change.Fields() {
+		fmt.Printf("\t%s\n", f)
+	}
+	for _, f := range change.Unrepresentable() {
+		fmt.Printf("\t%s\n", f)
+	}
+}
+
+// analyseAudio adds the properties of the audio to the status with
+// --audio, and the findings about its integrity with --integrity.
+func analyseAudio(pathname string, status *Mp3FileStatus) error {
+	if *audioFlag {
+		info, err := AnalyseMpegAudio(pathname, *status)
+		if err != nil {
+			return err
+		}
+		if info == nil && !*integrityFlag {
+			status.Findings = append(status.Findings, Finding{"audio", "no MPEG audio frame found"})
+		}
+		status.Audio = info
+	}
+	if *integrityFlag {
+		findings, err := CheckMpegIntegrity(pathname, *status)
+		if err != nil {
+			return err
+		}
+		status.Findings = append(status.Findings, findings...)
+	}
+	return nil
+}
+
+// printFileStatus prints a file that failed a policy or has findings to
+// report, followed by the findings.  Findings about tags are reported
+// with --validate and those about the audio with --integrity.  With
+// --verbose every file is printed with its tags and all its findings.
+func printFileStatus(pathname string, status Mp3FileStatus, failed policyList) {
+	var findings []Finding
+	for _, f := range status.Findings {
+		if *verboseFlag || *validateFlag || *integrityFlag && f.Field == "audio" {
+			findings = append(findings, f)
+		}
+	}
+	if *verboseFlag {
+		if len(failed) > 0 {
+			fmt.Printf("%s: %s (fails %s)\n", pathname, status, failed.String())
+		} else {
+			fmt.Printf("%s: %s\n", pathname, status)
+		}
+		if status.Audio != nil {
+			fmt.Printf("\taudio: %s\n", status.Audio)
+		}
+		if status.TagPlus != nil {
+			for _, line := range status.TagPlus.Describe() {
+				fmt.Printf("\tTAG+ %s\n", line)
+			}
+		}
+	} else if len(failed) > 0 || len(findings) > 0 {
+		fmt.Println(pathname)
+	}
+	for _, f := range findings {
+		fmt.Printf("\t%s\n", f)
+	}
+}
+
+// checkPolicies tests a file status against the policies given by
+// --require options, counting the failures for each policy.
+func checkPolicies(status Mp3FileStatus) policyList {
+	policies := requireFlag
+	if len(policies) == 0 {
+		policies = defaultPolicies
+	}
+	var failed policyList
+	for _, p := range policies {
+		if !p.Satisfied(status) {
+			p.Failures++
+			failed = append(failed, p)
+		}
+	}
+	return failed
+}
+
 func newReader(reader io.Reader, encoding string) (io.Reader, error) {
 	switch encoding {
 	case "ShiftJIS":
@@ -252,6 +778,35 @@ func newReader(reader io.Reader, encoding string) (io.Reader, error) {
 	}
 }
 
+// newWriter returns a writer encoding text in the given encoding, where
+// characters outside the encoding are written as '?'.  The writer must
+// be closed to flush it.
+func newWriter(writer io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "ShiftJIS":
+		t := transform.Chain(runes.Map(func(r rune) rune {
+			if _, err := japanese.ShiftJIS.NewEncoder().String(string(r)); err != nil {
+				return '?'
+			}
+			return r
+		}), japanese.ShiftJIS.NewEncoder())
+		return transform.NewWriter(writer, t), nil
+	case "", "UTF-8":
+		return nopWriteCloser{writer}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported encoding: %s", encoding)
+	}
+}
+
+// nopWriteCloser is a writer with a Close method doing nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
 func parseListFile(listfile string, encoding string) (files []string, err error) {
 	f, _ := os.Open(listfile)
 	defer f.Close()
@@ -0,0 +1,335 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const id3v1TagSize = 128
+
+// id3v1Field is the position of a text field in an ID3v1 tag.
+type id3v1Field struct {
+	Name  string
+	Start int
+	End   int
+}
+
+var id3v1Fields = [...]id3v1Field{
+	{"title", 3, 33},
+	{"artist", 33, 63},
+	{"album", 63, 93},
+	{"year", 93, 97},
+	{"comment", 97, 127},
+}
+
+// ID3v1Tag is a raw ID3v1 tag found in a file.
+type ID3v1Tag struct {
+	Revision byte  // 0 for ID3v1.0, 1 for ID3v1.1
+	Offset   int64 // Offset of the tag from the start of the file
+	Raw      [id3v1TagSize]byte
+}
+
+// Track returns the track number of an ID3v1.1 tag, or 0 for an ID3v1.0
+// tag.
+func (t *ID3v1Tag) Track() byte {
+	if t.Revision == 0 {
+		return 0
+	}
+	return t.Raw[126]
+}
+
+// Genre returns the genre number.
+func (t *ID3v1Tag) Genre() byte {
+	return t.Raw[127]
+}
+
+// ID3v1Fields holds the values of the fields of an ID3v1.1 tag.
+type ID3v1Fields struct {
+	Title   string
+	Artist  string
+	Album   string
+	Year    string
+	Comment string
+	Track   byte
+	Genre   byte
+}
+
+// tagFieldNames are the names of the fields commands set from values
+// such as the parts of a path, in the order of the fields of a tag.
+var tagFieldNames = []string{"title", "artist", "album", "year", "comment", "track", "genre"}
+
+// With returns a copy of the fields with the given values, keyed by the
+// names in tagFieldNames, replacing the current ones.  A track is given
+// as a number, such as "3" or "3/12", and a genre as a name or a number.
+func (v ID3v1Fields) With(values map[string]string) *ID3v1Fields {
+	for name, value := range values {
+		switch name {
+		case "title":
+			v.Title = value
+		case "artist":
+			v.Artist = value
+		case "album":
+			v.Album = value
+		case "year":
+			v.Year = value
+		case "comment":
+			v.Comment = value
+		case "track":
+			v.Track = id3v2Track(value)
+		case "genre":
+			v.Genre = id3v1GenreNumber(value)
+		}
+	}
+	return &v
+}
+
+// checkID3v1Values checks values of fields, keyed by the names in
+// tagFieldNames, against the limits of an ID3v1.1 tag: text must fit its
+// field in the codepage of ID3v1 text, where a comment shares its last
+// two bytes with a track number, a year must have four digits, a track
+// number, such as "3" or "3/12" as With reads it, must be between 1 and
+// 255, and a genre must be one of the ID3v1 genres.  With shortened,
+// text too long for its field is left to --shorten to fit.
+func checkID3v1Values(values map[string]string, shortened bool) error {
+	for i, name := range tagFieldNames {
+		value, ok := values[name]
+		if !ok || value == "" {
+			continue
+		}
+		switch name {
+		case "track":
+			if id3v2Track(value) == 0 {
+				return fmt.Errorf("Track number must be between 1 and 255: %s", value)
+			}
+		case "genre":
+			if id3v1GenreNumber(value) == id3v1GenreNone {
+				return fmt.Errorf("Unknown genre: %s", value)
+			}
+		case "year":
+			if len(value) != 4 || strings.Trim(value, "0123456789") != "" {
+				return fmt.Errorf("Year must have four digits: %s", value)
+			}
+		default:
+			if runes := id3v1Codepage.Unrepresentable(value); len(runes) > 0 {
+				return fmt.Errorf("The %s has characters outside %s (%s): %s",
+					name, id3v1Codepage.Name, quoteRunes(runes), value)
+			}
+			size := id3v1Fields[i].End - id3v1Fields[i].Start
+			if name == "comment" && values["track"] != "" {
+				size -= 2
+			}
+			if n := len(id3v1Codepage.Encode(value)); n > size && !shortened {
+				return fmt.Errorf("The %s is %d bytes, longer than %d bytes: %s", name, n, size, value)
+			}
+		}
+	}
+	return nil
+}
+
+// checkID3v1ValuesFor checks values of fields like checkID3v1Values
+// for a file, where a comment must leave room for the track number of
+// its ID3v1 tag unless the values set the track too.
+func checkID3v1ValuesFor(values map[string]string, status Mp3FileStatus, shortened bool) error {
+	checked := make(map[string]string)
+	for name, value := range values {
+		checked[name] = value
+	}
+	if _, ok := values["track"]; !ok && status.ID3v1 != nil && status.ID3v1.Track() != 0 {
+		checked["track"] = strconv.Itoa(int(status.ID3v1.Track()))
+	}
+	return checkID3v1Values(checked, shortened)
+}
+
+// Encode builds a 128-byte ID3v1.1 tag, encoding text in the codepage of
+// ID3v1 text and truncating it to the length of each field between
+// characters.  A track number of 0 makes an ID3v1.0 tag with a 30-byte
+// comment.
+func (v *ID3v1Fields) Encode() []byte {
+	b := make([]byte, id3v1TagSize)
+	copy(b, "TAG")
+	for i, value := range []string{v.Title, v.Artist, v.Album, v.Year, v.Comment} {
+		field := id3v1Fields[i]
+		if field.Name == "comment" && v.Track != 0 {
+			field.End = 125
+		}
+		size := field.End - field.Start
+		copy(b[field.Start:field.End], id3v1Codepage.Encode(cutText(value, size, id3v1Codepage.Encode)))
+	}
+	if v.Track != 0 {
+		b[126] = v.Track
+	}
+	b[127] = v.Genre
+	return b
+}
+
+// Fields decodes the fields of the tag from the codepage of ID3v1 text.
+func (t *ID3v1Tag) Fields() *ID3v1Fields {
+	v := &ID3v1Fields{Track: t.Track(), Genre: t.Genre()}
+	for i, field := range t.fields() {
+		value := id3v1Codepage.Decode(trimID3v1String(t.Raw[field.Start:field.End]))
+		switch i {
+		case 0:
+			v.Title = value
+		case 1:
+			v.Artist = value
+		case 2:
+			v.Album = value
+		case 3:
+			v.Year = value
+		case 4:
+			v.Comment = value
+		}
+	}
+	return v
+}
+
+// encodeLatin1 encodes a string in ISO-8859-1, replacing characters
+// outside it with '?'.
+func encodeLatin1(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	return b
+}
+
+// decodeLatin1 decodes a string of ISO-8859-1 bytes.
+func decodeLatin1(s string) string {
+	r := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		r[i] = rune(s[i])
+	}
+	return string(r)
+}
+
+// fields returns the positions of the text fields of the tag, where the
+// comment field of an ID3v1.1 tag is shortened to 28 bytes.
+func (t *ID3v1Tag) fields() []id3v1Field {
+	fields := id3v1Fields
+	if t.Revision == 1 {
+		fields[4].End = 125
+	}
+	return fields[:]
+}
+
+func (t *ID3v1Tag) String() string {
+	return fmt.Sprintf("ID3v1.%d", t.Revision)
+}
+
+// validate checks the fields of the tag, then the track number bytes.
+// Devices sorting by track number read byte 126 regardless of the
+// revision, so an ID3v1.0 tag whose byte 126 holds a control byte rather
+// than text, which reads as a track number behind a non-zero byte 125,
+// and an ID3v1.1 tag with a track number of 0 are reported.  A comment
+// of an ID3v1.0 tag running to the end of the field is well formed.
+func (t *ID3v1Tag) validate() []Finding {
+	var findings []Finding
+	for _, field := range t.fields() {
+		for _, what := range validateID3v1Field(field.Name, t.Raw[field.Start:field.End]) {
+			findings = append(findings, Finding{"ID3v1 " + field.Name, what})
+		}
+	}
+	switch genre := t.Genre(); {
+	case genre == id3v1GenreNone:
+		findings = append(findings, Finding{"ID3v1 genre", "genre is not set"})
+	case int(genre) >= len(id3v1Genres):
+		findings = append(findings, Finding{"ID3v1 genre",
+			fmt.Sprintf("genre %d is undefined", genre)})
+	}
+	if t.Revision == 0 {
+		if c := t.Raw[126]; c != 0 && c < 0x20 {
+			findings = append(findings, Finding{"ID3v1 comment",
+				fmt.Sprintf("byte 125 is 0x%02x instead of zero, "+
+					"so track number %d in byte 126 is not read as ID3v1.1", t.Raw[125], c)})
+		}
+	} else if t.Track() == 0 {
+		findings = append(findings, Finding{"ID3v1 track", "track number is 0"})
+	}
+	return findings
+}
+
+// validateID3v1Field checks the bytes of a text field for emptiness,
+// non-printable bytes and inconsistent padding, and a year field for
+// non-numeric values.
+func validateID3v1Field(name string, b []byte) []string {
+	text := bytes.TrimRight(b, " \x00")
+	padding := b[len(text):]
+	var findings []string
+	switch {
+	case len(bytes.Trim(b, "\x00")) == 0:
+		return []string{"empty"}
+	case len(bytes.TrimSpace(text)) == 0:
+		return []string{"whitespace only"}
+	}
+	if bytes.IndexByte(padding, 0) >= 0 && bytes.IndexByte(padding, ' ') >= 0 {
+		findings = append(findings, "padded with mixed NUL and space bytes")
+	}
+	for i, c := range text {
+		if c < 0x20 || c == 0x7F {
+			findings = append(findings,
+				fmt.Sprintf("non-printable byte 0x%02x at position %d", c, i+1))
+			break
+		}
+	}
+	if name == "year" {
+		if strings.Trim(string(b), "0123456789") != "" {
+			findings = append(findings, fmt.Sprintf("year %q is not numeric", text))
+		}
+	}
+	return findings
+}
+
+// parseID3v1Tag parses a 128-byte ID3v1 tag.  A tag whose comment field
+// has a zero byte at offset 125 is an ID3v1.1 tag carrying a track number
+// in the last byte of the comment field; otherwise it is an ID3v1.0 tag
+// with a 30-byte comment.
+func parseID3v1Tag(b []byte) (*ID3v1Tag, bool) {
+	if len(b) < id3v1TagSize || string(b[0:3]) != "TAG" {
+		return nil, false
+	}
+	t := &ID3v1Tag{}
+	copy(t.Raw[:], b)
+	if b[125] == 0 {
+		t.Revision = 1
+	}
+	return t, true
+}
+
+// readID3v1Tag reads the ID3v1 tag ending at the offset end.
+func readID3v1Tag(r io.ReaderAt, end int64) (*ID3v1Tag, error) {
+	if end < id3v1TagSize {
+		return nil, nil
+	}
+	b := make([]byte, id3v1TagSize)
+	if _, err := r.ReadAt(b, end-id3v1TagSize); err != nil {
+		return nil, err
+	}
+	t, ok := parseID3v1Tag(b)
+	if !ok {
+		return nil, nil
+	}
+	t.Offset = end - id3v1TagSize
+	return t, nil
+}
@@ -0,0 +1,210 @@
+// +build unittest
+
+package main
+
+import (
+	"testing"
+)
+
+// makeID3v1Tag builds a raw ID3v1 tag with valid fields except for the
+// given comment field bytes placed from offset 97.
+func makeID3v1Tag(comment []byte) []byte {
+	b := make([]byte, id3v1TagSize)
+	copy(b, "TAG")
+	copy(b[3:33], "Title")
+	copy(b[33:63], "Artist")
+	copy(b[63:93], "Album")
+	copy(b[93:97], "2020")
+	copy(b[97:127], comment)
+	b[127] = 17
+	return b
+}
+
+func TestParseID3v1Tag(t *testing.T) {
+	tests := []struct {
+		name     string
+		comment  []byte
+		revision byte
+		track    byte
+		findings []string
+	}{
+		{
+			name:     "ID3v1.1 with track number",
+			comment:  append(append([]byte("Comment"), make([]byte, 22)...), 7),
+			revision: 1,
+			track:    7,
+		},
+		{
+			name:     "ID3v1.1 with track 0",
+			comment:  []byte("Short comment"),
+			revision: 1,
+			findings: []string{"ID3v1 track: track number is 0"},
+		},
+		{
+			name:     "ID3v1.0 padded with spaces",
+			comment:  []byte("Comment                       "),
+			revision: 0,
+		},
+		{
+			name:     "ID3v1.0 with 30-byte comment",
+			comment:  []byte("A comment of thirty characters"),
+			revision: 0,
+		},
+		{
+			name:     "ID3v1.0 padded with spaces before a track number",
+			comment:  append([]byte("Comment                      "), 7),
+			revision: 0,
+			findings: []string{
+				"ID3v1 comment: non-printable byte 0x07 at position 30",
+				"ID3v1 comment: byte 125 is 0x20 instead of zero, " +
+					"so track number 7 in byte 126 is not read as ID3v1.1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, ok := parseID3v1Tag(makeID3v1Tag(tt.comment))
+			if !ok {
+				t.Fatal("parseID3v1Tag() failed")
+			}
+			if tag.Revision != tt.revision {
+				t.Errorf("Revision = %v, expected %v", tag.Revision, tt.revision)
+			}
+			if tag.Track() != tt.track {
+				t.Errorf("Track() = %v, expected %v", tag.Track(), tt.track)
+			}
+			findings := tag.validate()
+			if len(findings) != len(tt.findings) {
+				t.Fatalf("validate() = %v, expected %v", findings, tt.findings)
+			}
+			for i, f := range findings {
+				if f.String() != tt.findings[i] {
+					t.Errorf("validate()[%d] = %q, expected %q", i, f, tt.findings[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateID3v1Field(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    string
+		data     string
+		expected []string
+	}{
+		{"Valid title", "title", "Title\x00\x00\x00", nil},
+		{"Empty title", "title", "\x00\x00\x00\x00", []string{"empty"}},
+		{"Spaces only", "artist", "    \x00\x00", []string{"whitespace only"}},
+		{"Mixed padding", "album", "Album   \x00\x00", []string{"padded with mixed NUL and space bytes"}},
+		{"Non-printable byte", "title", "Ti\x01tle\x00", []string{"non-printable byte 0x01 at position 3"}},
+		{"Embedded NUL", "title", "Ti\x00tle\x00", []string{"non-printable byte 0x00 at position 3"}},
+		{"Valid year", "year", "1999", nil},
+		{"Non-numeric year", "year", "19x9", []string{`year "19x9" is not numeric`}},
+		{"Short year", "year", "99\x00\x00", []string{`year "99" is not numeric`}},
+		{"Empty year", "year", "\x00\x00\x00\x00", []string{"empty"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateID3v1Field(tt.field, []byte(tt.data))
+			if len(got) != len(tt.expected) {
+				t.Fatalf("validateID3v1Field() = %q, expected %q", got, tt.expected)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("validateID3v1Field()[%d] = %q, expected %q", i, got[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateID3v1Genre(t *testing.T) {
+	for _, tt := range []struct {
+		genre    byte
+		expected string
+	}{
+		{17, ""},
+		{191, ""},
+		{192, "ID3v1 genre: genre 192 is undefined"},
+		{255, "ID3v1 genre: genre is not set"},
+	} {
+		b := makeID3v1Tag(append([]byte("Comment"), make([]byte, 22)...))
+		b[126] = 1
+		b[127] = tt.genre
+		tag, _ := parseID3v1Tag(b)
+		got := ""
+		if findings := tag.validate(); len(findings) > 0 {
+			got = findings[0].String()
+		}
+		if got != tt.expected {
+			t.Errorf("validate() with genre %d = %q, expected %q", tt.genre, got, tt.expected)
+		}
+	}
+}
+
+func TestParseID3v1TagInvalid(t *testing.T) {
+	if _, ok := parseID3v1Tag([]byte("TAG")); ok {
+		t.Error("parseID3v1Tag() accepted a short tag")
+	}
+	b := makeID3v1Tag(nil)
+	copy(b, "APE")
+	if _, ok := parseID3v1Tag(b); ok {
+		t.Error("parseID3v1Tag() accepted a tag without the TAG identifier")
+	}
+}
+
+func TestCheckID3v1Values(t *testing.T) {
+	tests := []struct {
+		values  map[string]string
+		wantErr bool
+	}{
+		{map[string]string{"title": "012345678901234567890123456789"}, false},
+		{map[string]string{"title": "0123456789012345678901234567890"}, true},
+		{map[string]string{"artist": "Café"}, false},
+		{map[string]string{"artist": "日本"}, true},
+		{map[string]string{"comment": "012345678901234567890123456789"}, false},
+		{map[string]string{"comment": "012345678901234567890123456789", "track": "1"}, true},
+		{map[string]string{"comment": "", "track": "", "genre": ""}, false},
+		{map[string]string{"year": "2020"}, false},
+		{map[string]string{"year": "20"}, true},
+		{map[string]string{"track": "255"}, false},
+		{map[string]string{"track": "0"}, true},
+		{map[string]string{"track": "1/12"}, false},
+		{map[string]string{"track": "256/300"}, true},
+		{map[string]string{"track": "A1"}, true},
+		{map[string]string{"genre": "Psybient"}, false},
+		{map[string]string{"genre": "17"}, false},
+		{map[string]string{"genre": "Nope"}, true},
+	}
+	for _, tt := range tests {
+		if err := checkID3v1Values(tt.values, false); (err != nil) != tt.wantErr {
+			t.Errorf("checkID3v1Values(%v) error = %v, wantErr %v", tt.values, err, tt.wantErr)
+		}
+	}
+	long := map[string]string{"title": "0123456789012345678901234567890"}
+	if err := checkID3v1Values(long, true); err != nil {
+		t.Errorf("checkID3v1Values(%v) error = %v for text to shorten", long, err)
+	}
+	if err := checkID3v1Values(map[string]string{"artist": "日本"}, true); err == nil {
+		t.Error("checkID3v1Values() accepted characters outside ISO-8859-1 for text to shorten")
+	}
+}
+
+func TestCheckID3v1ValuesFor(t *testing.T) {
+	v10, _ := parseID3v1Tag(makeID3v1Tag([]byte("Comment")))
+	v11, _ := parseID3v1Tag(makeID3v1Tag([]byte("Comment\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03")))
+	comment := map[string]string{"comment": "012345678901234567890123456789"}
+	if err := checkID3v1ValuesFor(comment, Mp3FileStatus{ID3v1: v10}, false); err != nil {
+		t.Errorf("checkID3v1ValuesFor() error = %v for an ID3v1.0 tag", err)
+	}
+	if err := checkID3v1ValuesFor(comment, Mp3FileStatus{ID3v1: v11}, false); err == nil {
+		t.Error("checkID3v1ValuesFor() accepted a 30-byte comment for a tag keeping track 3")
+	}
+	comment["track"] = ""
+	if err := checkID3v1ValuesFor(comment, Mp3FileStatus{ID3v1: v11}, false); err != nil {
+		t.Errorf("checkID3v1ValuesFor() error = %v clearing the track", err)
+	}
+}
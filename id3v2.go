@@ -0,0 +1,146 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+const id3v2HeaderSize = 10
+
+// Flags in the ID3v2 tag header.
+const (
+	id3v2FlagUnsynchronisation = 0x80
+	id3v2FlagExtendedHeader    = 0x40
+	id3v2FlagExperimental      = 0x20
+	id3v2FlagFooter            = 0x10
+)
+
+// ID3v2Header describes the header of an ID3v2 tag found in a file.
+type ID3v2Header struct {
+	Version  byte  // Major version: 2, 3 or 4
+	Revision byte  // Revision number
+	Flags    byte  // Header flags
+	Size     int64 // Size of the tag excluding the header and the footer
+	Offset   int64 // Offset of the tag header from the start of the file
+	Appended bool  // true if the tag was located through its footer
+}
+
+// HasFooter returns true if the tag ends with a footer.
+func (h *ID3v2Header) HasFooter() bool {
+	return h.Version == 4 && h.Flags&id3v2FlagFooter != 0
+}
+
+// TotalSize returns the size of the tag including the header and the
+// footer.
+func (h *ID3v2Header) TotalSize() int64 {
+	size := h.Size + id3v2HeaderSize
+	if h.HasFooter() {
+		size += id3v2HeaderSize
+	}
+	return size
+}
+
+func (h *ID3v2Header) String() string {
+	s := fmt.Sprintf("ID3v2.%d.%d (flags 0x%02x, size %d",
+		h.Version, h.Revision, h.Flags, h.Size)
+	if h.Appended {
+		s += ", appended"
+	}
+	return s + ")"
+}
+
+// parseID3v2Header parses a 10-byte ID3v2 header or footer.  The id
+// parameter is "ID3" for a header and "3DI" for a footer.
+func parseID3v2Header(b []byte, id string) (*ID3v2Header, bool) {
+	if len(b) < id3v2HeaderSize || string(b[0:3]) != id {
+		return nil, false
+	}
+	if b[3] < 2 || b[3] > 4 || b[4] == 0xFF {
+		return nil, false
+	}
+	size, ok := syncsafeInt(b[6:10])
+	if !ok {
+		return nil, false
+	}
+	return &ID3v2Header{
+		Version:  b[3],
+		Revision: b[4],
+		Flags:    b[5],
+		Size:     size,
+	}, true
+}
+
+// syncsafeInt decodes a 28-bit synchsafe integer, where the most
+// significant bit of each byte is always zero.
+func syncsafeInt(b []byte) (int64, bool) {
+	var n int64
+	for _, c := range b {
+		if c&0x80 != 0 {
+			return 0, false
+		}
+		n = n<<7 | int64(c)
+	}
+	return n, true
+}
+
+// readID3v2Header reads the ID3v2 header at the start of a file.
+func readID3v2Header(r io.ReaderAt, fileSize int64) (*ID3v2Header, error) {
+	b := make([]byte, id3v2HeaderSize)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	h, ok := parseID3v2Header(b, "ID3")
+	if !ok || h.TotalSize() > fileSize {
+		return nil, nil
+	}
+	return h, nil
+}
+
+// readAppendedID3v2Header reads an ID3v2.4 tag appended to a file,
+// identifying it by its footer that ends at the offset end.
+func readAppendedID3v2Header(r io.ReaderAt, end int64) (*ID3v2Header, error) {
+	if end < 2*id3v2HeaderSize {
+		return nil, nil
+	}
+	b := make([]byte, id3v2HeaderSize)
+	if _, err := r.ReadAt(b, end-id3v2HeaderSize); err != nil {
+		return nil, err
+	}
+	footer, ok := parseID3v2Header(b, "3DI")
+	if !ok || footer.Version != 4 {
+		return nil, nil
+	}
+	offset := end - 2*id3v2HeaderSize - footer.Size
+	if offset < 0 {
+		return nil, nil
+	}
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+	h, ok := parseID3v2Header(b, "ID3")
+	if !ok || !h.HasFooter() || h.Size != footer.Size {
+		return nil, nil
+	}
+	h.Offset = offset
+	h.Appended = true
+	return h, nil
+}
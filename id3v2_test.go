@@ -0,0 +1,45 @@
+// +build unittest
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseID3v2Header(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		id    string
+		ok    bool
+		size  int64
+		total int64
+	}{
+		{"ID3v2.3 header", []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0x02, 0x01}, "ID3", true, 257, 267},
+		{"ID3v2.4 with footer", []byte{'I', 'D', '3', 4, 0, 0x10, 0, 0, 0, 0x7F}, "ID3", true, 127, 147},
+		{"ID3v2.4 footer", []byte{'3', 'D', 'I', 4, 0, 0x10, 0, 0, 0, 0x01}, "3DI", true, 1, 21},
+		{"Unknown version", []byte{'I', 'D', '3', 5, 0, 0, 0, 0, 0, 0}, "ID3", false, 0, 0},
+		{"Invalid revision", []byte{'I', 'D', '3', 3, 0xFF, 0, 0, 0, 0, 0}, "ID3", false, 0, 0},
+		{"Not synchsafe", []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0x80, 0}, "ID3", false, 0, 0},
+		{"Wrong identifier", []byte{'T', 'A', 'G', 3, 0, 0, 0, 0, 0, 0}, "ID3", false, 0, 0},
+		{"Too short", []byte{'I', 'D', '3', 3}, "ID3", false, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, ok := parseID3v2Header(tt.data, tt.id)
+			if ok != tt.ok {
+				t.Fatalf("parseID3v2Header() ok = %v, expected %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if h.Size != tt.size {
+				t.Errorf("Size = %v, expected %v", h.Size, tt.size)
+			}
+			if h.TotalSize() != tt.total {
+				t.Errorf("TotalSize() = %v, expected %v", h.TotalSize(), tt.total)
+			}
+		})
+	}
+}
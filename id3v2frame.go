@@ -0,0 +1,409 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// Frame format flags of ID3v2.3
+const (
+	id3v23FrameCompression = 0x80
+	id3v23FrameEncryption  = 0x40
+	id3v23FrameGrouping    = 0x20
+)
+
+// Frame format flags of ID3v2.4
+const (
+	id3v24FrameGrouping          = 0x40
+	id3v24FrameCompression       = 0x08
+	id3v24FrameEncryption        = 0x04
+	id3v24FrameUnsynchronisation = 0x02
+	id3v24FrameDataLength        = 0x01
+)
+
+// Text encodings of ID3v2 frames
+const (
+	id3v2EncodingLatin1  = 0
+	id3v2EncodingUTF16   = 1 // UTF-16 with byte order mark
+	id3v2EncodingUTF16BE = 2 // ID3v2.4 only
+	id3v2EncodingUTF8    = 3 // ID3v2.4 only
+)
+
+// id3v2Frame is a frame of an ID3v2.3 or ID3v2.4 tag, with
+// unsynchronisation and compression undone, except the compression of
+// an encrypted frame.
+type id3v2Frame struct {
+	ID         string
+	Status     byte   // Frame status flags in the ID3v2.3 layout
+	Group      int    // Group identifier, or -1 if the frame is not grouped
+	Encrypted  bool   // Whether Data starts with an encryption method byte
+	Compressed bool   // Whether encrypted Data is also compressed
+	DataLength uint32 // Decompressed size of compressed encrypted Data
+	Data       []byte
+}
+
+// readID3v2Tag reads the frames of the ID3v2.3 or ID3v2.4 tag whose
+// header is h.
+func readID3v2Tag(r io.ReaderAt, h *ID3v2Header) ([]id3v2Frame, error) {
+	b := make([]byte, h.Size)
+	if _, err := r.ReadAt(b, h.Offset+id3v2HeaderSize); err != nil {
+		return nil, err
+	}
+	return parseID3v2Frames(h, b)
+}
+
+// parseID3v2Frames parses the body of an ID3v2.3 or ID3v2.4 tag after
+// its header, skipping the extended header and stopping at the padding.
+func parseID3v2Frames(h *ID3v2Header, b []byte) ([]id3v2Frame, error) {
+	if h.Version != 3 && h.Version != 4 {
+		return nil, fmt.Errorf("Unsupported ID3v2 version: 2.%d", h.Version)
+	}
+	if h.Version == 3 && h.Flags&id3v2FlagUnsynchronisation != 0 {
+		b = removeUnsynchronisation(b)
+	}
+	if h.Flags&id3v2FlagExtendedHeader != 0 {
+		if len(b) < 4 {
+			return nil, errTruncatedID3v2Tag
+		}
+		var size int64
+		if h.Version == 3 {
+			size = 4 + int64(binary.BigEndian.Uint32(b))
+		} else {
+			size, _ = syncsafeInt(b[0:4])
+		}
+		if size > int64(len(b)) {
+			return nil, errTruncatedID3v2Tag
+		}
+		b = b[size:]
+	}
+	var frames []id3v2Frame
+	for len(b) >= id3v2HeaderSize && isID3v2FrameID(b[0:4]) {
+		f := id3v2Frame{ID: string(b[0:4]), Group: -1}
+		var size int64
+		if h.Version == 3 {
+			size = int64(binary.BigEndian.Uint32(b[4:8]))
+		} else {
+			size, _ = syncsafeInt(b[4:8])
+		}
+		status, format := b[8], b[9]
+		if size > int64(len(b)-id3v2HeaderSize) {
+			return nil, errTruncatedID3v2Tag
+		}
+		data := b[id3v2HeaderSize : id3v2HeaderSize+size]
+		b = b[id3v2HeaderSize+size:]
+		var err error
+		if h.Version == 3 {
+			f.Status = status
+			data, err = parseID3v23FrameData(&f, format, data)
+		} else {
+			f.Status = status << 1
+			unsync := h.Flags&id3v2FlagUnsynchronisation != 0
+			data, err = parseID3v24FrameData(&f, format, unsync, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		f.Data = data
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
+
+// errTruncatedID3v2Tag is the error for a frame running past the end of
+// its tag.
+var errTruncatedID3v2Tag = errors.New("Truncated ID3v2 frame")
+
+// isID3v2FrameID returns true if b is a frame ID of four upper-case
+// letters and digits.
+func isID3v2FrameID(b []byte) bool {
+	for _, c := range b {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseID3v23FrameData reads the extra bytes after an ID3v2.3 frame
+// header, which are the decompressed size, the encryption method and the
+// group identifier in this order, and decompresses the data.
+func parseID3v23FrameData(f *id3v2Frame, format byte, data []byte) ([]byte, error) {
+	compressed := format&id3v23FrameCompression != 0
+	var size uint32
+	if compressed {
+		if len(data) < 4 {
+			return nil, errTruncatedID3v2Tag
+		}
+		size, data = binary.BigEndian.Uint32(data), data[4:]
+	}
+	var method []byte
+	if format&id3v23FrameEncryption != 0 {
+		if len(data) < 1 {
+			return nil, errTruncatedID3v2Tag
+		}
+		f.Encrypted = true
+		method, data = data[0:1], data[1:]
+	}
+	if format&id3v23FrameGrouping != 0 {
+		if len(data) < 1 {
+			return nil, errTruncatedID3v2Tag
+		}
+		f.Group, data = int(data[0]), data[1:]
+	}
+	if f.Encrypted {
+		f.Compressed, f.DataLength = compressed, size
+		return append(method, data...), nil
+	}
+	if compressed {
+		return decompressFrame(data)
+	}
+	return data, nil
+}
+
+// parseID3v24FrameData reads the extra bytes after an ID3v2.4 frame
+// header, which are the group identifier, the encryption method and the
+// data length indicator in this order, and undoes unsynchronisation and
+// compression.
+func parseID3v24FrameData(f *id3v2Frame, format byte, unsync bool, data []byte) ([]byte, error) {
+	if format&id3v24FrameGrouping != 0 {
+		if len(data) < 1 {
+			return nil, errTruncatedID3v2Tag
+		}
+		f.Group, data = int(data[0]), data[1:]
+	}
+	var method []byte
+	if format&id3v24FrameEncryption != 0 {
+		if len(data) < 1 {
+			return nil, errTruncatedID3v2Tag
+		}
+		f.Encrypted = true
+		method, data = data[0:1], data[1:]
+	}
+	var size uint32
+	if format&id3v24FrameDataLength != 0 {
+		if len(data) < 4 {
+			return nil, errTruncatedID3v2Tag
+		}
+		n, _ := syncsafeInt(data[0:4])
+		size, data = uint32(n), data[4:]
+	}
+	if unsync || format&id3v24FrameUnsynchronisation != 0 {
+		data = removeUnsynchronisation(data)
+	}
+	if f.Encrypted {
+		f.Compressed, f.DataLength = format&id3v24FrameCompression != 0, size
+		return append(method, data...), nil
+	}
+	if format&id3v24FrameCompression != 0 {
+		return decompressFrame(data)
+	}
+	return data, nil
+}
+
+// decompressFrame inflates the zlib-compressed data of a frame.
+func decompressFrame(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// removeUnsynchronisation removes the zero byte inserted after every
+// 0xFF byte by unsynchronisation.
+func removeUnsynchronisation(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
+
+// encodeID3v23Tag builds an ID3v2.3 tag of the frames, padded with zero
+// bytes to at least minSize bytes including the header.  Encrypted
+// frames keep their compression along with their decompressed size.
+func encodeID3v23Tag(frames []id3v2Frame, minSize int64) []byte {
+	var body []byte
+	for _, f := range frames {
+		var format byte
+		var extra []byte
+		data := f.Data
+		if f.Encrypted && f.Compressed {
+			format |= id3v23FrameCompression
+			extra = binary.BigEndian.AppendUint32(extra, f.DataLength)
+		}
+		if f.Encrypted && len(data) > 0 {
+			format |= id3v23FrameEncryption
+			extra, data = append(extra, data[0]), data[1:]
+		}
+		if f.Group >= 0 {
+			format |= id3v23FrameGrouping
+			extra = append(extra, byte(f.Group))
+		}
+		header := make([]byte, id3v2HeaderSize)
+		copy(header, f.ID)
+		binary.BigEndian.PutUint32(header[4:8], uint32(len(extra)+len(data)))
+		header[8], header[9] = f.Status, format
+		body = append(body, header...)
+		body = append(body, extra...)
+		body = append(body, data...)
+	}
+	if pad := minSize - id3v2HeaderSize - int64(len(body)); pad > 0 {
+		body = append(body, make([]byte, pad)...)
+	}
+	tag := []byte{'I', 'D', '3', 3, 0, 0}
+	return append(append(tag, syncsafeBytes(len(body))...), body...)
+}
+
+// syncsafeBytes encodes a 28-bit synchsafe integer.
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+// decodeID3v2Text decodes text in the given encoding of an ID3v2 frame.
+// UTF-16 without a byte order mark is taken as little-endian.
+func decodeID3v2Text(encoding byte, b []byte) string {
+	switch encoding {
+	case id3v2EncodingUTF16, id3v2EncodingUTF16BE:
+		big := encoding == id3v2EncodingUTF16BE
+		if len(b) >= 2 && (b[0] == 0xFE && b[1] == 0xFF || b[0] == 0xFF && b[1] == 0xFE) {
+			big, b = b[0] == 0xFE, b[2:]
+		}
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			if big {
+				u[i] = binary.BigEndian.Uint16(b[2*i:])
+			} else {
+				u[i] = binary.LittleEndian.Uint16(b[2*i:])
+			}
+		}
+		return string(utf16.Decode(u))
+	case id3v2EncodingUTF8:
+		return string(b)
+	default:
+		return decodeLatin1(string(b))
+	}
+}
+
+// encodeID3v2Text encodes text in the given encoding of an ID3v2.3
+// frame, which is ISO-8859-1 or UTF-16 with a little-endian byte order
+// mark.
+func encodeID3v2Text(encoding byte, s string) []byte {
+	if encoding != id3v2EncodingUTF16 {
+		return encodeLatin1(s)
+	}
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2+2*len(u))
+	b[0], b[1] = 0xFF, 0xFE
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2+2*i:], c)
+	}
+	return b
+}
+
+// id3v2Terminator returns the string terminator of an encoding.
+func id3v2Terminator(encoding byte) []byte {
+	if encoding == id3v2EncodingUTF16 || encoding == id3v2EncodingUTF16BE {
+		return []byte{0, 0}
+	}
+	return []byte{0}
+}
+
+// splitID3v2String splits the first terminated string in the given
+// encoding off b, returning the string and the rest of b.  Without a
+// terminator the whole of b is the string.
+func splitID3v2String(encoding byte, b []byte) (string, []byte) {
+	term := id3v2Terminator(encoding)
+	for i := 0; i+len(term) <= len(b); i += len(term) {
+		if bytes.Equal(b[i:i+len(term)], term) {
+			return decodeID3v2Text(encoding, b[:i]), b[i+len(term):]
+		}
+	}
+	return decodeID3v2Text(encoding, b), nil
+}
+
+// splitID3v2Strings decodes the list of strings in the given encoding
+// separated by terminators, ignoring a terminator at the end.
+func splitID3v2Strings(encoding byte, b []byte) []string {
+	var values []string
+	for len(b) > 0 {
+		var s string
+		s, b = splitID3v2String(encoding, b)
+		values = append(values, s)
+	}
+	return values
+}
+
+// id3v2Text is the text of a text or comment frame of an ID3v2 tag.
+type id3v2Text struct {
+	ID          string
+	Text        string // Text, with multiple values separated with NUL
+	Frame       int    // Index of the frame in the tag
+	Language    string // Language of a COMM frame
+	Description string // Description of a COMM frame
+}
+
+// readID3v2Texts reads the text of the text frames, other than TXXX, and
+// the comment frames of the ID3v2.3 or ID3v2.4 tag at the start of a
+// file.  Encrypted frames and other versions of tags are skipped.
+func readID3v2Texts(pathname string, status Mp3FileStatus) ([]id3v2Text, error) {
+	if len(status.ID3v2) == 0 || status.ID3v2[0].Appended {
+		return nil, nil
+	}
+	h := status.ID3v2[0]
+	if h.Version != 3 && h.Version != 4 {
+		return nil, nil
+	}
+	f, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	frames, err := readID3v2Tag(f, h)
+	if err != nil {
+		return nil, id3Error{pathname, err.Error()}
+	}
+	var texts []id3v2Text
+	for i, frame := range frames {
+		t := id3v2Text{ID: frame.ID, Frame: i}
+		switch {
+		case frame.Encrypted:
+			continue
+		case frame.ID == "COMM":
+			t.Language, t.Description, t.Text = commentFrameText(frame)
+		case frame.ID[0] == 'T' && frame.ID != "TXXX":
+			t.Text = strings.Join(textFrameValues(frame), "\x00")
+		default:
+			continue
+		}
+		texts = append(texts, t)
+	}
+	return texts, nil
+}
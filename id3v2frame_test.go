@@ -0,0 +1,132 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"reflect"
+	"testing"
+)
+
+// makeID3v24Frame builds an ID3v2.4 frame with a synchsafe size.
+func makeID3v24Frame(id string, format byte, content []byte) []byte {
+	b := append([]byte(id), syncsafeBytes(len(content))...)
+	return append(append(b, 0, format), content...)
+}
+
+// makeID3v23FrameFormat builds an ID3v2.3 frame with format flags.
+func makeID3v23FrameFormat(id string, format byte, content []byte) []byte {
+	b := makeID3v23Frame(id, content)
+	b[9] = format
+	return b
+}
+
+// makeID3v2TagWithFrames builds an ID3v2 tag of the given version
+// holding the frames, followed by some padding.
+func makeID3v2TagWithFrames(version byte, frames ...[]byte) []byte {
+	body := append(bytes.Join(frames, nil), make([]byte, 16)...)
+	tag := makeID3v2Tag(version, 0, len(body))
+	copy(tag[id3v2HeaderSize:], body)
+	return tag
+}
+
+func TestParseID3v2Frames(t *testing.T) {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write([]byte("\x00Compressed"))
+	w.Close()
+	tests := []struct {
+		name     string
+		version  byte
+		flags    byte
+		body     []byte
+		expected []id3v2Frame
+	}{
+		{"ID3v2.3 frame", 3, 0,
+			makeID3v23Frame("TIT2", []byte("\x00Title")),
+			[]id3v2Frame{{ID: "TIT2", Group: -1, Data: []byte("\x00Title")}}},
+		{"ID3v2.3 unsynchronised tag", 3, id3v2FlagUnsynchronisation,
+			append(makeID3v23Frame("PRIV", []byte("\xFF\xE0"))[:11], 0x00, 0xE0),
+			[]id3v2Frame{{ID: "PRIV", Group: -1, Data: []byte("\xFF\xE0")}}},
+		{"ID3v2.4 frame with synchsafe size", 4, 0,
+			makeID3v24Frame("PRIV", 0, make([]byte, 200)),
+			[]id3v2Frame{{ID: "PRIV", Group: -1, Data: make([]byte, 200)}}},
+		{"ID3v2.4 grouped frame with data length", 4, 0,
+			makeID3v24Frame("TIT2", id3v24FrameGrouping|id3v24FrameDataLength, []byte("\x07\x00\x00\x00\x06\x00Title")),
+			[]id3v2Frame{{ID: "TIT2", Group: 7, Data: []byte("\x00Title")}}},
+		{"ID3v2.4 unsynchronised frame", 4, 0,
+			makeID3v24Frame("PRIV", id3v24FrameUnsynchronisation, []byte("\xFF\x00\xE0")),
+			[]id3v2Frame{{ID: "PRIV", Group: -1, Data: []byte("\xFF\xE0")}}},
+		{"ID3v2.3 encrypted and compressed frame", 3, 0,
+			makeID3v23FrameFormat("PRIV", id3v23FrameCompression|id3v23FrameEncryption, []byte("\x00\x00\x01\x00\x80Secret")),
+			[]id3v2Frame{{ID: "PRIV", Group: -1, Encrypted: true, Compressed: true, DataLength: 256, Data: []byte("\x80Secret")}}},
+		{"ID3v2.4 encrypted and compressed frame", 4, 0,
+			makeID3v24Frame("PRIV", id3v24FrameCompression|id3v24FrameEncryption|id3v24FrameDataLength, []byte("\x80\x00\x00\x02\x00Secret")),
+			[]id3v2Frame{{ID: "PRIV", Group: -1, Encrypted: true, Compressed: true, DataLength: 256, Data: []byte("\x80Secret")}}},
+		{"ID3v2.4 compressed frame", 4, 0,
+			makeID3v24Frame("TIT2", id3v24FrameCompression|id3v24FrameDataLength,
+				append([]byte{0, 0, 0, 11}, compressed.Bytes()...)),
+			[]id3v2Frame{{ID: "TIT2", Group: -1, Data: []byte("\x00Compressed")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &ID3v2Header{Version: tt.version, Flags: tt.flags}
+			got, err := parseID3v2Frames(h, append(tt.body, 0, 0, 0, 0))
+			if err != nil {
+				t.Fatalf("parseID3v2Frames() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseID3v2Frames() = %+v, expected %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseID3v2FramesTruncated(t *testing.T) {
+	h := &ID3v2Header{Version: 3}
+	if _, err := parseID3v2Frames(h, makeID3v23Frame("TIT2", []byte("\x00Title"))[:12]); err == nil {
+		t.Error("parseID3v2Frames() accepted a truncated frame")
+	}
+}
+
+func TestEncodeID3v23Tag(t *testing.T) {
+	frames := []id3v2Frame{
+		{ID: "TIT2", Group: -1, Data: []byte("\x00Title")},
+		{ID: "PRIV", Status: 0x80, Group: 3, Data: make([]byte, 200)},
+		{ID: "PRIV", Group: 5, Encrypted: true, Compressed: true, DataLength: 256, Data: []byte("\x80Secret")},
+	}
+	b := encodeID3v23Tag(frames, 512)
+	if len(b) != 512 {
+		t.Errorf("tag size = %d, expected 512", len(b))
+	}
+	h, ok := parseID3v2Header(b, "ID3")
+	if !ok || h.Version != 3 {
+		t.Fatalf("parseID3v2Header() = %v, %v", h, ok)
+	}
+	got, err := parseID3v2Frames(h, b[id3v2HeaderSize:])
+	if err != nil || !reflect.DeepEqual(got, frames) {
+		t.Errorf("frames = %+v, %v, expected %+v", got, err, frames)
+	}
+}
+
+func TestID3v2Text(t *testing.T) {
+	tests := []struct {
+		encoding byte
+		b        []byte
+		expected []string
+	}{
+		{id3v2EncodingLatin1, []byte("Caf\xe9\x00Bar\x00"), []string{"Café", "Bar"}},
+		{id3v2EncodingUTF16, []byte("\xff\xfeA\x00\x00\x00\xfe\xff\x00B"), []string{"A", "B"}},
+		{id3v2EncodingUTF16BE, []byte("\x65\xe5\x67\x2c"), []string{"日本"}},
+		{id3v2EncodingUTF8, []byte("日本\x00"), []string{"日本"}},
+	}
+	for _, tt := range tests {
+		if got := splitID3v2Strings(tt.encoding, tt.b); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("splitID3v2Strings(%d, %q) = %q, expected %q", tt.encoding, tt.b, got, tt.expected)
+		}
+	}
+	if got := decodeID3v2Text(id3v2EncodingUTF16, encodeID3v2Text(id3v2EncodingUTF16, "日本 🎵")); got != "日本 🎵" {
+		t.Errorf("UTF-16 round trip = %q", got)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// importRow is a row of an imported inventory, keyed by the columns of
+// exportColumns.  A column missing from the inventory is missing from
+// the row, leaving the field alone.
+type importRow map[string]string
+
+// readImport reads the rows of an inventory in the given format, which
+// is "csv" or "json", as written by writeExport.  A CSV file must have a
+// header naming the columns, and every row must have a path.
+func readImport(r io.Reader, format string) ([]importRow, error) {
+	var rows []importRow
+	switch format {
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		records, err := cr.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, nil
+		}
+		header := records[0]
+		if len(header) > 0 {
+			header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		}
+		for _, record := range records[1:] {
+			row := importRow{}
+			for i, value := range record {
+				if i < len(header) {
+					row[header[i]] = value
+				}
+			}
+			rows = append(rows, row)
+		}
+	case "json":
+		d := json.NewDecoder(r)
+		d.UseNumber()
+		var objects []map[string]interface{}
+		if err := d.Decode(&objects); err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			row := importRow{}
+			for key, value := range object {
+				switch v := value.(type) {
+				case string:
+					row[key] = v
+				case json.Number, bool:
+					row[key] = fmt.Sprint(v)
+				case nil:
+					row[key] = ""
+				}
+			}
+			rows = append(rows, row)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported format: %s", format)
+	}
+	for i, row := range rows {
+		if row["path"] == "" {
+			return nil, fmt.Errorf("Row %d has no path", i+1)
+		}
+	}
+	return rows, nil
+}
+
+// ImportChange is a change planned to the tags of a file from a row of
+// an imported inventory, which has only the fields the row changes.
+type ImportChange struct {
+	Path  string
+	ID3v1 *TagChange   // Change to the ID3v1 tag, or nil if there is none
+	ID3v2 *ID3v2Change // Change to the ID3v2 tag, or nil if there is none
+}
+
+// Changed returns true if any tag changes.
+func (c *ImportChange) Changed() bool {
+	return c.ID3v1 != nil || c.ID3v2 != nil
+}
+
+// PlanImport plans setting the fields of a file to the values of a row
+// of an imported inventory.  The columns named as in tagFieldNames set
+// the ID3v1 tag, and those prefixed with "v2_" set the ID3v2 tag.  Only
+// the values differing from what the file would export are set, and
+// they are checked against the limits of ID3v1 as with the set command.
+func PlanImport(pathname string, status Mp3FileStatus, row importRow) (*ImportChange, error) {
+	current, err := newExportRecord(pathname, status, nil)
+	if err != nil {
+		return nil, err
+	}
+	exported := current.columns()
+	c := &ImportChange{Path: pathname}
+
+	v1 := make(map[string]string)
+	for _, name := range tagFieldNames {
+		if value, ok := row[name]; ok && value != exported[name] {
+			v1[name] = value
+		}
+	}
+	if len(v1) > 0 {
+		if err := checkID3v1ValuesFor(v1, status, false); err != nil {
+			return nil, id3Error{pathname, err.Error()}
+		}
+		fields := ID3v1Fields{Genre: id3v1GenreNone}
+		if status.ID3v1 != nil {
+			fields = *status.ID3v1.Fields()
+		}
+		if change := newTagChange(pathname, status, fields.With(v1)); change.Changed() {
+			c.ID3v1 = change
+		}
+	}
+
+	v2 := make(map[string]string)
+	for _, name := range id3v2FieldNames {
+		if value, ok := row["v2_"+name]; ok && value != exported["v2_"+name] {
+			v2[name] = value
+		}
+	}
+	if len(v2) > 0 {
+		change, err := PlanID3v2Change(pathname, status, v2)
+		if err != nil {
+			return nil, err
+		}
+		if change.Changed() {
+			c.ID3v2 = change
+		}
+	}
+	return c, nil
+}
+
+// importCommand writes the fields changed in an inventory, as exported
+// by the export command and edited, to the files it lists, then prints
+// the number of files updated, unchanged, missing and rejected.
+func importCommand(input string) int {
+	f, err := os.Open(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	defer f.Close()
+	reader, err := newReader(f, *encodingFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	rows, err := readImport(reader, *formatFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, id3Error{input, err.Error()})
+		return 1
+	}
+	var nUpdated, nUnchanged, nMissing, nRejected int
+	for _, row := range rows {
+		pathname := row["path"]
+		if _, err := os.Stat(pathname); os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, id3Error{pathname, "Missing file"})
+			nMissing++
+			continue
+		}
+		updated, err := importFile(pathname, row)
+		switch {
+		case err != nil:
+			fmt.Fprintln(os.Stderr, err.Error())
+			nRejected++
+		case updated:
+			nUpdated++
+		default:
+			nUnchanged++
+		}
+	}
+	updated := "updated"
+	if *dryRunFlag {
+		updated = "would be updated"
+	}
+	fmt.Fprintf(os.Stderr, "%d file(s) %s, %d unchanged, %d missing, %d rejected\n",
+		nUpdated, updated, nUnchanged, nMissing, nRejected)
+	if nUpdated+nUnchanged == 0 && nMissing+nRejected > 0 {
+		return 1
+	}
+	return 0
+}
+
+// importFile writes the fields changed by a row of an imported inventory
+// to a file, the ID3v2 tag first, and returns true if the file changes,
+// or would change with --dry-run, which prints the changes instead.
+func importFile(pathname string, row importRow) (bool, error) {
+	status, err := CheckMp3FileStatus(pathname)
+	if err != nil {
+		return false, err
+	}
+	change, err := PlanImport(pathname, status, row)
+	if err != nil || !change.Changed() {
+		return false, err
+	}
+	if _, err := applyTagChanges(change.ID3v1, change.ID3v2, status); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,86 @@
+// +build unittest
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadImport(t *testing.T) {
+	csvRows, err := readImport(strings.NewReader("\ufeffpath,title,v2_album\nsong.mp3,Title\nother.mp3,,Album\n"), "csv")
+	if err != nil {
+		t.Fatalf("readImport() error = %v", err)
+	}
+	expected := []importRow{
+		{"path": "song.mp3", "title": "Title"},
+		{"path": "other.mp3", "title": "", "v2_album": "Album"},
+	}
+	if !reflect.DeepEqual(csvRows, expected) {
+		t.Errorf("readImport(csv) = %v, expected %v", csvRows, expected)
+	}
+	jsonRows, err := readImport(strings.NewReader(`[{"path": "song.mp3", "title": "Title", "sample_rate": 44100, "findings": []}]`), "json")
+	if err != nil {
+		t.Fatalf("readImport() error = %v", err)
+	}
+	if expected := []importRow{{"path": "song.mp3", "title": "Title", "sample_rate": "44100"}}; !reflect.DeepEqual(jsonRows, expected) {
+		t.Errorf("readImport(json) = %v, expected %v", jsonRows, expected)
+	}
+	if _, err := readImport(strings.NewReader("title\nTitle\n"), "csv"); err == nil {
+		t.Error("readImport() accepted a row without a path")
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	content := append(makeID3v23TagWithFrames("TIT2", "Title", "TALB", "Album"), makeMpegFrames(10, 0xFF, 0xFB, 0x90, 0x44)...)
+	content = append(content, makeID3v1Tag(append(append([]byte("Comment"), make([]byte, 22)...), 5))...)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		row     importRow
+		v1      bool
+		v2      []string
+		wantErr bool
+	}{
+		{"Unchanged", importRow{"path": path, "title": "Title", "genre": "Rock", "v2_title": "Title", "v2_album": "Album"}, false, nil, false},
+		{"Genre by number", importRow{"path": path, "genre": "17"}, false, nil, false},
+		{"ID3v1 title", importRow{"path": path, "title": "New Title", "v2_title": "Title"}, true, nil, false},
+		{"ID3v2 album", importRow{"path": path, "title": "Title", "v2_album": "New Album", "v2_album_artist": "Various"}, false, []string{"TALB", "TPE2"}, false},
+		{"Comment too long for track", importRow{"path": path, "comment": "012345678901234567890123456789"}, false, nil, true},
+		{"Unknown genre", importRow{"path": path, "genre": "Nope"}, false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := PlanImport(path, status, tt.row)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PlanImport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if (c.ID3v1 != nil) != tt.v1 {
+				t.Errorf("ID3v1 = %v, expected change %v", c.ID3v1, tt.v1)
+			}
+			var frames []string
+			if c.ID3v2 != nil {
+				for _, f := range c.ID3v2.Fields {
+					frames = append(frames, f.Name)
+				}
+			}
+			if !reflect.DeepEqual(frames, tt.v2) {
+				t.Errorf("ID3v2 frames = %v, expected %v", frames, tt.v2)
+			}
+		})
+	}
+}
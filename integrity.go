@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Maximum number of resynchronisation gaps reported individually
+const maxReportedGaps = 10
+
+// mpegCRC16 computes the CRC-16 protecting MPEG audio frames, with the
+// polynomial 0x8005 and the initial value 0xFFFF.
+func mpegCRC16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// checkFrameCRC verifies the CRC-16 of a protected Layer III frame,
+// which covers the last two bytes of the header and the side
+// information.  It returns true for frames it cannot verify.
+func checkFrameCRC(h mpegFrameHeader, frame []byte) bool {
+	if !h.Protected || h.Layer != 3 {
+		return true
+	}
+	end := mpegHeaderSize + 2 + h.SideInfoSize()
+	if len(frame) < end {
+		return true
+	}
+	crc := mpegCRC16(0xFFFF, frame[2:4])
+	crc = mpegCRC16(crc, frame[6:end])
+	return crc == binary.BigEndian.Uint16(frame[4:6])
+}
+
+// CheckMpegIntegrity walks every frame of the MPEG audio stream of an
+// MP3 file and reports junk before the first frame, gaps the stream had
+// to be resynchronised over, frames failing their CRC-16, a truncated
+// last frame, trailing junk after the last frame, and a number of frames
+// or bytes disagreeing with the Xing, Info or VBRI header.
+func CheckMpegIntegrity(pathname string, status Mp3FileStatus) ([]Finding, error) {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var findings []Finding
+	report := func(format string, a ...interface{}) {
+		findings = append(findings, Finding{"audio", fmt.Sprintf(format, a...)})
+	}
+
+	w := newMpegWalker(f, status.AudioStart, status.AudioEnd)
+	var vbr *vbrHeader
+	var first, last mpegFrame
+	var lastData []byte
+	var frames, gaps, badCRC int
+	var firstBadCRC int64
+	for {
+		frame, data, skipped, err := w.Next()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF {
+			if frames == 0 {
+				report("no MPEG audio frame found")
+				return findings, nil
+			}
+			if skipped > 0 {
+				report("%d bytes of trailing junk at offset %d", skipped, status.AudioEnd-skipped)
+			}
+			break
+		}
+		switch {
+		case skipped > 0 && frames == 0:
+			report("%d bytes of junk before the first frame at offset %d", skipped, frame.Offset)
+		case skipped > 0:
+			gaps++
+			if gaps <= maxReportedGaps {
+				report("resynchronised over a gap of %d bytes at offset %d", skipped, frame.Offset-skipped)
+			}
+		}
+		if !checkFrameCRC(frame.Header, data) {
+			if badCRC == 0 {
+				firstBadCRC = frame.Offset
+			}
+			badCRC++
+		}
+		if frames == 0 {
+			first = frame
+			vbr = parseVBRHeader(frame.Header, data)
+		}
+		last, lastData = frame, data
+		frames++
+	}
+	if gaps > maxReportedGaps {
+		report("%d more resynchronisation gaps", gaps-maxReportedGaps)
+	}
+	if badCRC > 0 {
+		report("%d frames fail the CRC check, the first at offset %d", badCRC, firstBadCRC)
+	}
+	if size := last.Header.FrameSize(); len(lastData) < size {
+		report("last frame at offset %d is truncated to %d of %d bytes", last.Offset, len(lastData), size)
+	}
+	if vbr != nil {
+		if vbr.Frames > 0 && vbr.Frames != frames-1 {
+			report("%s header declares %d frames, but %d are found", vbr.Kind, vbr.Frames, frames-1)
+		}
+		if actual := last.Offset + int64(len(lastData)) - first.Offset; vbr.Bytes > 0 && int64(vbr.Bytes) != actual {
+			report("%s header declares %d bytes, but the stream has %d", vbr.Kind, vbr.Bytes, actual)
+		}
+	}
+	return findings, nil
+}
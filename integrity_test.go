@@ -0,0 +1,115 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeProtectedFrame builds a CRC-protected MPEG-1 Layer III frame with
+// some side information, storing a correct or corrupted CRC.
+func makeProtectedFrame(valid bool) []byte {
+	frame := makeMpegFrame(0xFF, 0xFA, 0x90, 0x44)
+	for i := 6; i < 6+32; i++ {
+		frame[i] = byte(i)
+	}
+	crc := mpegCRC16(mpegCRC16(0xFFFF, frame[2:4]), frame[6:38])
+	if !valid {
+		crc++
+	}
+	binary.BigEndian.PutUint16(frame[4:6], crc)
+	return frame
+}
+
+func TestCheckMpegIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	cbr := makeMpegFrames(100, 0xFF, 0xFB, 0x90, 0x44)
+	half := 50 * 417
+
+	tests := []struct {
+		name     string
+		data     [][]byte
+		expected []string
+	}{
+		{
+			name: "Intact stream with tags",
+			data: [][]byte{makeID3v2Tag(3, 0, 64), cbr, makeID3v1Tag(nil)},
+		},
+		{
+			name:     "Truncated last frame",
+			data:     [][]byte{cbr[:len(cbr)-100]},
+			expected: []string{"audio: last frame at offset 41283 is truncated to 317 of 417 bytes"},
+		},
+		{
+			name:     "Garbage in the middle",
+			data:     [][]byte{cbr[:half], make([]byte, 100), cbr[half:]},
+			expected: []string{"audio: resynchronised over a gap of 100 bytes at offset 20850"},
+		},
+		{
+			name:     "Leading and trailing junk",
+			data:     [][]byte{[]byte("junk"), cbr, []byte("more junk")},
+			expected: []string{
+				"audio: 4 bytes of junk before the first frame at offset 4",
+				"audio: 9 bytes of trailing junk at offset 41704",
+			},
+		},
+		{
+			name:     "Xing header disagreeing with the stream",
+			data:     [][]byte{makeXingFrame("Xing", 100, 417*101), cbr[:half]},
+			expected: []string{
+				"audio: Xing header declares 100 frames, but 50 are found",
+				"audio: Xing header declares 42117 bytes, but the stream has 21267",
+			},
+		},
+		{
+			name: "Valid CRC",
+			data: [][]byte{makeProtectedFrame(true), makeProtectedFrame(true)},
+		},
+		{
+			name:     "Invalid CRC",
+			data:     [][]byte{makeProtectedFrame(true), makeProtectedFrame(false), makeProtectedFrame(false)},
+			expected: []string{"audio: 2 frames fail the CRC check, the first at offset 417"},
+		},
+		{
+			name:     "No audio",
+			data:     [][]byte{makeID3v2Tag(3, 0, 64), makeID3v1Tag(nil)},
+			expected: []string{"audio: no MPEG audio frame found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "test.mp3")
+			if err := os.WriteFile(path, bytes.Join(tt.data, nil), 0644); err != nil {
+				t.Fatal(err)
+			}
+			status, err := CheckMp3FileStatus(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := CheckMpegIntegrity(path, status)
+			if err != nil {
+				t.Fatalf("CheckMpegIntegrity() error = %v", err)
+			}
+			if len(got) != len(tt.expected) {
+				t.Fatalf("CheckMpegIntegrity() = %v, expected %q", got, tt.expected)
+			}
+			for i := range got {
+				if got[i].String() != tt.expected[i] {
+					t.Errorf("CheckMpegIntegrity()[%d] = %q, expected %q", i, got[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMpegCRC16(t *testing.T) {
+	// CRC-16/CMS of "123456789"
+	if got := mpegCRC16(0xFFFF, []byte("123456789")); got != 0xAEE7 {
+		t.Errorf("mpegCRC16() = %04x, expected aee7", got)
+	}
+}
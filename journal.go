@@ -0,0 +1,369 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Name of the file recording the entries of a run in its journal
+// directory
+const journalFileName = "journal.jsonl"
+
+// journalRegion records the bytes a write replaced at an offset of a
+// file, and the bytes it wrote there.
+type journalRegion struct {
+	Offset int64
+	Old    []byte
+	New    []byte
+}
+
+// journalEntry records the state of a file before a write, so that the
+// write can be undone, and the size the write leaves the file with, so
+// that a file changed since can be detected.
+type journalEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	NewSize int64
+	Regions []journalRegion
+}
+
+// journal records the writes of a run in a directory of its own under
+// the journal directory, named after the time the run started.
+type journal struct {
+	Root string
+	ID   string
+	file *os.File
+	err  error // Error opening the journal file, after which writes go unrecorded
+}
+
+// newJournal returns a journal for a run, whose directory is created on
+// the first write.
+func newJournal(root string) *journal {
+	return &journal{Root: root, ID: time.Now().Format("20060102T150405.000000000")}
+}
+
+// defaultJournalDir returns the journal directory used unless --journal
+// gives one.
+func defaultJournalDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".id3stat-journal"
+	}
+	return filepath.Join(home, ".id3stat", "journal")
+}
+
+// newJournalEntry captures the state of a file before the splices are
+// applied to it.
+func newJournalEntry(pathname string, splices []fileSplice) (*journalEntry, error) {
+	abs, err1 := filepath.Abs(pathname)
+	if err1 != nil {
+		return nil, err1
+	}
+	f, err2 := os.Open(pathname)
+	if err2 != nil {
+		return nil, err2
+	}
+	defer f.Close()
+	stat, err3 := f.Stat()
+	if err3 != nil {
+		return nil, err3
+	}
+	e := &journalEntry{
+		Path:    abs,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+		NewSize: splicedSize(stat.Size(), splices),
+	}
+	for _, s := range splices {
+		if s.Offset+s.Length > stat.Size() {
+			return nil, id3Error{pathname, "Cannot write beyond the end of file"}
+		}
+		old := make([]byte, s.Length)
+		if _, err4 := f.ReadAt(old, s.Offset); err4 != nil {
+			return nil, err4
+		}
+		e.Regions = append(e.Regions, journalRegion{s.Offset, old, s.Data})
+	}
+	return e, nil
+}
+
+// Record appends an entry to the journal, and syncs it to the disk
+// before the write it records takes place.  If the journal file cannot
+// be opened, a warning is printed once and the writes of the run go
+// unrecorded rather than failing.
+func (j *journal) Record(e *journalEntry) error {
+	if j.err != nil {
+		return nil
+	}
+	if j.file == nil {
+		if err := j.open(); err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: Cannot open the journal, so writes cannot be undone:", err)
+			j.err = err
+			return nil
+		}
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// open creates the directory of the run and opens its journal file.
+func (j *journal) open() error {
+	dir := filepath.Join(j.Root, j.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, journalFileName),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.file = f
+	return nil
+}
+
+// Close closes the journal file of the run, if any write was recorded.
+// A nil journal, as with --no-journal, has nothing to close.
+func (j *journal) Close() error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// listJournalRuns returns the IDs of the runs recorded in a journal
+// directory, oldest first.
+func listJournalRuns(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var runs []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(root, e.Name(), journalFileName)); err == nil {
+			runs = append(runs, e.Name())
+		}
+	}
+	sort.Strings(runs)
+	return runs, nil
+}
+
+// readJournalRun reads the entries of a run, in the order they were
+// recorded.  A run ID of "last" reads the latest run.
+func readJournalRun(root, id string) (string, []*journalEntry, error) {
+	if id == "last" {
+		runs, err := listJournalRuns(root)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(runs) == 0 {
+			return "", nil, fmt.Errorf("No run recorded in %s", root)
+		}
+		id = runs[len(runs)-1]
+	}
+	f, err := os.Open(filepath.Join(root, id, journalFileName))
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	var entries []*journalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		e := &journalEntry{}
+		if err := json.Unmarshal(line, e); err != nil {
+			// The last entry of a run interrupted while recording it
+			break
+		}
+		entries = append(entries, e)
+	}
+	return id, entries, scanner.Err()
+}
+
+// matches returns true if the file is in the state recorded by the old
+// side of an entry, or by the new side, where the regions are shifted
+// by the change in length of the regions before them.
+func (e *journalEntry) matches(f *os.File, size int64, old bool) (bool, error) {
+	if old && size != e.Size || !old && size != e.NewSize {
+		return false, nil
+	}
+	var shift int64
+	for _, r := range e.Regions {
+		offset, expected := r.Offset, r.Old
+		if !old {
+			offset, expected = r.Offset+shift, r.New
+		}
+		b := make([]byte, len(expected))
+		if _, err := f.ReadAt(b, offset); err != nil && err != io.EOF {
+			return false, err
+		}
+		if !bytes.Equal(b, expected) {
+			return false, nil
+		}
+		shift += int64(len(r.New) - len(r.Old))
+	}
+	return true, nil
+}
+
+// Undo restores the file of an entry to its state before the write,
+// with its modification time.  It refuses to touch a file changed since
+// the write, and does nothing to a file the write never reached.
+func (e *journalEntry) Undo() (bool, error) {
+	f, err1 := os.Open(e.Path)
+	if err1 != nil {
+		return false, err1
+	}
+	defer f.Close()
+	stat, err2 := f.Stat()
+	if err2 != nil {
+		return false, err2
+	}
+	if ok, err := e.matches(f, stat.Size(), false); err != nil {
+		return false, err
+	} else if !ok {
+		if unchanged, _ := e.matches(f, stat.Size(), true); unchanged {
+			return false, nil
+		}
+		return false, id3Error{e.Path, "File has changed since the write, not restored"}
+	}
+	f.Close()
+	var splices []fileSplice
+	var shift int64
+	for _, r := range e.Regions {
+		splices = append(splices, fileSplice{r.Offset + shift, int64(len(r.New)), r.Old})
+		shift += int64(len(r.New) - len(r.Old))
+	}
+	if err := writeSplices(e.Path, splices); err != nil {
+		return false, err
+	}
+	return true, os.Chtimes(e.Path, fileAccessTime(stat), e.ModTime)
+}
+
+// undoJournalRun undoes the writes of a run in reverse order, only to
+// the given files if any, and prints every file restored.
+func undoJournalRun(root, id string, pathnames []string) (nSuccess int, nError int) {
+	id, entries, err := readJournalRun(root, id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 0, 1
+	}
+	selected := make(map[string]bool)
+	for _, pathname := range pathnames {
+		if abs, err := filepath.Abs(pathname); err == nil {
+			selected[abs] = false
+		}
+	}
+	all := len(pathnames) == 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if _, ok := selected[e.Path]; !all && !ok {
+			continue
+		}
+		selected[e.Path] = true
+		restored, err := e.Undo()
+		switch {
+		case err != nil:
+			fmt.Fprintln(os.Stderr, err.Error())
+			nError++
+		case restored:
+			fmt.Printf("%s: restored from run %s\n", e.Path, id)
+			nSuccess++
+		default:
+			fmt.Printf("%s: not modified by run %s\n", e.Path, id)
+			nSuccess++
+		}
+	}
+	var missing []string
+	for pathname, found := range selected {
+		if !found {
+			missing = append(missing, pathname)
+		}
+	}
+	sort.Strings(missing)
+	for _, pathname := range missing {
+		fmt.Fprintln(os.Stderr, id3Error{pathname, "File not recorded in run " + id}.Error())
+		nError++
+	}
+	return nSuccess, nError
+}
+
+// printJournalRuns prints the runs recorded in a journal directory with
+// the number of files each wrote.
+func printJournalRuns(root string) error {
+	runs, err := listJournalRuns(root)
+	if err != nil {
+		return err
+	}
+	for _, id := range runs {
+		_, entries, err := readJournalRun(root, id)
+		if err != nil {
+			return err
+		}
+		files := make(map[string]bool)
+		for _, e := range entries {
+			files[e.Path] = true
+		}
+		fmt.Printf("%s: %d file(s)\n", id, len(files))
+	}
+	if len(runs) == 0 {
+		fmt.Printf("No run recorded in %s\n", strings.TrimSuffix(root, string(filepath.Separator)))
+	}
+	return nil
+}
+
+// undoCommand restores the files written by a run recorded in the
+// journal, or lists the runs recorded when no run is given.
+func undoCommand(args []string) int {
+	flag.Usage = printUsage
+	flag.CommandLine.Parse(args)
+	if flag.NArg() == 0 {
+		if err := printJournalRuns(*journalFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		return 0
+	}
+	nSuccess, nError := undoJournalRun(*journalFlag, flag.Arg(0), flag.Args()[1:])
+	if nSuccess == 0 && nError > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeJournalledFix writes an MP3 file without ID3v1 tag and appends
+// one, recording the write in the journal.
+func writeJournalledFix(t *testing.T, j *journal, path string) []byte {
+	original := makeMpegFrames(4, 0xFF, 0xFB, 0x90, 0x44)
+	if err := os.WriteFile(path, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	status, err := CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &ID3v1Fields{Title: "Title", Genre: id3v1GenreNone}
+	if err := writeTagChange(newTagChange(path, status, v), j); err != nil {
+		t.Fatalf("writeTagChange() error = %v", err)
+	}
+	return original
+}
+
+func TestJournalUndo(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "journal")
+	j := newJournal(root)
+	path1 := filepath.Join(dir, "one.mp3")
+	path2 := filepath.Join(dir, "two.mp3")
+	original1 := writeJournalledFix(t, j, path1)
+	original2 := writeJournalledFix(t, j, path2)
+	j.Close()
+
+	runs, err := listJournalRuns(root)
+	if err != nil || len(runs) != 1 || runs[0] != j.ID {
+		t.Fatalf("listJournalRuns() = %v, %v, expected [%s]", runs, err, j.ID)
+	}
+
+	// Undo a single file of the run
+	if nSuccess, nError := undoJournalRun(root, "last", []string{path2}); nSuccess != 1 || nError != 0 {
+		t.Errorf("undoJournalRun() = %d, %d, expected 1, 0", nSuccess, nError)
+	}
+	if b, _ := os.ReadFile(path2); !bytes.Equal(b, original2) {
+		t.Errorf("%s is not restored", path2)
+	}
+	if stat, _ := os.Stat(path2); !stat.ModTime().Equal(time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)) {
+		t.Errorf("modification time = %v, expected it restored", stat.ModTime())
+	}
+	if b, _ := os.ReadFile(path1); len(b) != len(original1)+id3v1TagSize {
+		t.Errorf("%s is restored without being selected", path1)
+	}
+
+	// Undo the rest of the run, where the undone file is left alone
+	if nSuccess, nError := undoJournalRun(root, j.ID, nil); nSuccess != 2 || nError != 0 {
+		t.Errorf("undoJournalRun() = %d, %d, expected 2, 0", nSuccess, nError)
+	}
+	if b, _ := os.ReadFile(path1); !bytes.Equal(b, original1) {
+		t.Errorf("%s is not restored", path1)
+	}
+}
+
+func TestJournalUndoChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	j := newJournal(filepath.Join(dir, "journal"))
+	path := filepath.Join(dir, "test.mp3")
+	writeJournalledFix(t, j, path)
+	j.Close()
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0)
+	f.WriteAt([]byte("Edited"), 3+int64(len(makeMpegFrames(4, 0xFF, 0xFB, 0x90, 0x44))))
+	f.Close()
+
+	_, entries, err := readJournalRun(j.Root, j.ID)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("readJournalRun() = %v, %v", entries, err)
+	}
+	if restored, err := entries[0].Undo(); restored || err == nil {
+		t.Errorf("Undo() = %v, %v, expected a refusal", restored, err)
+	}
+	if b, _ := os.ReadFile(path); !bytes.Contains(b, []byte("Edited")) {
+		t.Error("changed file is overwritten")
+	}
+}
+
+func TestJournalUnavailable(t *testing.T) {
+	dir := t.TempDir()
+	// A regular file in place of the journal directory
+	root := filepath.Join(dir, "journal")
+	if err := os.WriteFile(root, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	j := newJournal(root)
+	path := filepath.Join(dir, "one.mp3")
+	original := writeJournalledFix(t, j, path)
+	if b, _ := os.ReadFile(path); len(b) != len(original)+id3v1TagSize {
+		t.Errorf("%s is not written without the journal", path)
+	}
+	if j.err == nil {
+		t.Error("journal error = nil, expected the error opening the journal")
+	}
+	if err := j.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
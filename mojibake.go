@@ -0,0 +1,263 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	encunicode "golang.org/x/text/encoding/unicode"
+)
+
+// utf8Codepage reads bytes as UTF-8, which taggers often store in fields
+// meant for a legacy codepage.
+var utf8Codepage = &codepage{"UTF-8", encunicode.UTF8, "UTF-8", nil}
+
+// parseMojibakeCandidates parses the codepages to try on text fields,
+// which are the names --codepage accepts and "utf-8", separated with
+// commas in the order of preference.
+func parseMojibakeCandidates(value string) ([]*codepage, error) {
+	var candidates []*codepage
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		cp, ok := lookupCodepage(name)
+		if strings.EqualFold(name, "utf-8") {
+			cp, ok = utf8Codepage, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("Unsupported codepage: %s", name)
+		}
+		candidates = append(candidates, cp)
+	}
+	return candidates, nil
+}
+
+// Mojibake is a text field that reads more plausibly in another
+// codepage than the one it was read in.
+type Mojibake struct {
+	Tag    string    // "ID3v1" or "ID3v2"
+	Field  string    // Name of the ID3v1 field, or ID of the ID3v2 frame
+	Read   string    // Text as read
+	ReadAs string    // Codepage the text was read in, e.g. "ISO-8859-1"
+	Likely string    // Codepage the text was likely written in, e.g. "CP932"
+	Text   string    // Text read in the likely codepage
+	frame  id3v2Text // Frame of an ID3v2 field
+}
+
+func (m Mojibake) String() string {
+	return fmt.Sprintf("%s %s: %q looks like %s read as %s: %q", m.Tag, m.Field, m.Read, m.Likely, m.ReadAs, m.Text)
+}
+
+// Repairable returns true if the text read in the likely codepage can be
+// stored in the field, which for an ID3v1 field means the codepage of
+// ID3v1 text has all its characters.
+func (m Mojibake) Repairable() bool {
+	return m.Tag != "ID3v1" || len(id3v1Codepage.Unrepresentable(m.Text)) == 0
+}
+
+// Minimum lead of the plausibility of a candidate reading over that of
+// the text as read
+const mojibakeMargin = 0.5
+
+// detectMojibake reads the bytes of a text field, read as text in the
+// codepage readAs, in each candidate codepage, and returns the first
+// reading clearly more plausible than the text, unless a later one is
+// clearly more plausible still.  Bytes making valid UTF-8 sequences are
+// rarely anything else, so their reading in UTF-8 gains a point.
+func detectMojibake(b []byte, text string, readAs *codepage, candidates []*codepage) (*codepage, string, bool) {
+	best, bestText := (*codepage)(nil), ""
+	bestScore := plausibility(text)
+	for _, cp := range candidates {
+		if cp.Name == readAs.Name {
+			continue
+		}
+		s, ok := cp.decodeStrict(b)
+		if !ok || s == text {
+			continue
+		}
+		score := plausibility(s)
+		if cp == utf8Codepage && score > -10 {
+			score = math.Max(score, 0) + 1
+		}
+		if score > bestScore+mojibakeMargin && score > 0 {
+			best, bestText, bestScore = cp, s, score
+		}
+	}
+	return best, bestText, best != nil
+}
+
+// decodeStrict decodes bytes in the codepage, failing on bytes not making
+// a character and on control characters other than NUL.
+func (cp *codepage) decodeStrict(b []byte) (string, bool) {
+	s, err := cp.encoding.NewDecoder().Bytes(b)
+	if err != nil {
+		return "", false
+	}
+	for _, r := range string(s) {
+		if r == utf8.RuneError || r != 0 && unicode.IsControl(r) {
+			return "", false
+		}
+	}
+	return string(s), true
+}
+
+// plausibility scores how plausible the characters outside ASCII of a
+// text are as the writing of a single language, from -1 for symbols and
+// scripts mixed within a word to 1.5 for kana.  Kanji and hanzi score
+// less than other letters, as most pairs of bytes read as one in CP932
+// and GBK.  Text with C1 control or private use characters scores -10,
+// and ASCII text scores 0.
+func plausibility(s string) float64 {
+	rs := []rune(s)
+	isASCIILetter := func(i int) bool {
+		return i >= 0 && i < len(rs) && rs[i] < 0x80 && unicode.IsLetter(rs[i])
+	}
+	total, n := 0.0, 0
+	for i, r := range rs {
+		if r < 0x80 {
+			continue
+		}
+		n++
+		nextToASCII := isASCIILetter(i-1) || isASCIILetter(i+1)
+		switch {
+		case r < 0xA0 || r == utf8.RuneError || unicode.Is(unicode.Co, r):
+			return -10
+		case r >= 0xFF61 && r <= 0xFF9F:
+			// Half-width katakana, which single bytes of CP932 read as
+			total--
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana) && !nextToASCII:
+			total += 1.5
+		case unicode.In(r, unicode.Hangul) && !nextToASCII:
+			total++
+		case unicode.Is(unicode.Han, r) && !nextToASCII:
+			total += 0.6
+		case unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana):
+		case unicode.In(r, unicode.Cyrillic, unicode.Greek):
+			if nextToASCII {
+				total--
+			} else {
+				total++
+			}
+		case unicode.IsLetter(r):
+			// Latin letters with accents are found within words
+			if nextToASCII {
+				total += 0.5
+			} else {
+				total -= 0.5
+			}
+		case r >= 0x3000 && r <= 0x303F || r >= 0xFF01 && r <= 0xFF5E:
+			total += 0.5
+		default:
+			total--
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / float64(n)
+}
+
+// originalBytes returns the bytes text read from a field of ID3v2 was
+// likely written as before it was read as ISO-8859-1, or as CP1252 by
+// software converting it to Unicode, with the codepage it was read in.
+// Text with characters outside both has no such bytes.
+func originalBytes(text string) ([]byte, *codepage, bool) {
+	for _, name := range []string{"latin1", "cp1252"} {
+		cp := codepages[name]
+		if len(cp.Unrepresentable(text)) == 0 {
+			return cp.Encode(text), cp, true
+		}
+	}
+	return nil, nil, false
+}
+
+// FindMojibake tries the candidate codepages on the text fields of the
+// ID3v1 tag and the text and comment frames of the ID3v2 tag at the
+// start of a file, and returns the fields reading more plausibly in one
+// of them.  ID3v1 fields are read from their bytes, and ID3v2 frames
+// from the bytes their text was likely written as.
+func FindMojibake(pathname string, status Mp3FileStatus, candidates []*codepage) ([]Mojibake, error) {
+	var found []Mojibake
+	if t := status.ID3v1; t != nil {
+		for _, field := range t.fields() {
+			b := []byte(trimID3v1String(t.Raw[field.Start:field.End]))
+			text := id3v1Codepage.Decode(string(b))
+			if cp, s, ok := detectMojibake(b, text, id3v1Codepage, candidates); ok {
+				found = append(found, Mojibake{Tag: "ID3v1", Field: field.Name, Read: text,
+					ReadAs: id3v1Codepage.Name, Likely: cp.Name, Text: s})
+			}
+		}
+	}
+	texts, err := readID3v2Texts(pathname, status)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range texts {
+		m := Mojibake{Tag: "ID3v2", Field: t.ID, Read: t.Text, frame: t}
+		b, readAs, ok := originalBytes(m.Read)
+		if !ok {
+			continue
+		}
+		if cp, s, ok := detectMojibake(b, m.Read, readAs, candidates); ok {
+			m.ReadAs, m.Likely, m.Text = readAs.Name, cp.Name, s
+			found = append(found, m)
+		}
+	}
+	return found, nil
+}
+
+// PlanMojibakeRepair plans rewriting the fields found by FindMojibake
+// with their text read in the likely codepage.  ID3v1 fields are
+// written in the codepage of ID3v1 text, leaving alone those not
+// repairable in it, and ID3v2 frames in ISO-8859-1 or UTF-16.
+func PlanMojibakeRepair(pathname string, status Mp3FileStatus, found []Mojibake) (*TagChange, *ID3v2Change, error) {
+	var texts []fieldText
+	for _, m := range found {
+		if m.Repairable() {
+			texts = append(texts, fieldText{m.Tag, m.Field, m.Text, m.frame})
+		}
+	}
+	return planTextChanges(pathname, status, texts, "repaired")
+}
+
+// checkMojibake prints the text fields of a file reading more plausibly
+// in one of the codepages given by --mojibake, and with
+// --repair-mojibake rewrites them, returning the status of the file.
+func checkMojibake(pathname string, status Mp3FileStatus) (Mp3FileStatus, error) {
+	found, err := FindMojibake(pathname, status, mojibakeCandidates)
+	if err != nil || len(found) == 0 {
+		return status, err
+	}
+	fmt.Printf("%s: mojibake found\n", pathname)
+	for _, m := range found {
+		fmt.Printf("\t%s\n", m)
+		if !m.Repairable() && *repairMojibakeFlag {
+			fmt.Printf("\t%s %s: cannot be repaired in %s\n", m.Tag, m.Field, id3v1Codepage.Name)
+		}
+	}
+	if !*repairMojibakeFlag {
+		return status, nil
+	}
+	v1Change, v2Change, err := PlanMojibakeRepair(pathname, status, found)
+	if err != nil {
+		return status, err
+	}
+	return applyTagChanges(v1Change, v2Change, status)
+}
@@ -0,0 +1,113 @@
+// +build unittest
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectMojibake(t *testing.T) {
+	candidates, err := parseMojibakeCandidates("utf-8,cp932,cp1251,euc-kr,gbk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latin1 := codepages["latin1"]
+	for _, tt := range []struct {
+		raw      string
+		likely   string
+		expected string
+	}{
+		{"Caf\xe9", "", ""},
+		{"Mot\xf6rhead", "", ""},
+		{"\xd6l\xe7\xfc", "", ""},
+		{"S\xe3o Paulo", "", ""},
+		{"\x83\x8d\x83\x62\x83\x4e", "CP932", "ロック"},
+		{"\x93\x8c\x8b\x9e", "CP932", "東京"},
+		{"\xca\xe8\xed\xee", "CP1251", "Кино"},
+		{"Caf\xc3\xa9", "UTF-8", "Café"},
+		{"\xe6\x9d\xb1\xe4\xba\xac", "UTF-8", "東京"},
+		{"\xc7\xd1\xb1\xb9", "EUC-KR", "한국"},
+	} {
+		cp, text, ok := detectMojibake([]byte(tt.raw), latin1.Decode(tt.raw), latin1, candidates)
+		likely := ""
+		if ok {
+			likely = cp.Name
+		}
+		if likely != tt.likely || text != tt.expected {
+			t.Errorf("detectMojibake(%q) = %s %q, expected %s %q", tt.raw, likely, text, tt.likely, tt.expected)
+		}
+	}
+	if _, err := parseMojibakeCandidates("cp932,utf-16"); err == nil {
+		t.Error("parseMojibakeCandidates() accepted UTF-16")
+	}
+}
+
+func TestRepairMojibake(t *testing.T) {
+	defer func(cp *codepage) { id3v1Codepage = cp }(id3v1Codepage)
+	id3v1Codepage = codepages["cp932"]
+
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	id3v2Tag := makeID3v23TagWithFrames("TIT2", "\x83\x8d\x83\x62\x83\x4e", "TPE1", "Caf\xc3\xa9",
+		"TALB", "Album", "COMM", "\xca\xe8\xed\xee")
+	v1 := makeID3v1Tag(nil)
+	copy(v1[3:33], "\xe6\x9d\xb1\xe4\xba\xac\x00\x00\x00\x00\x00\x00")
+	content := append(append(id3v2Tag, makeMpegFrames(4, 0xFF, 0xFB, 0x90, 0x44)...), v1...)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates, _ := parseMojibakeCandidates("utf-8,cp932,cp1251")
+	found, err := FindMojibake(path, status, candidates)
+	if err != nil {
+		t.Fatalf("FindMojibake() error = %v", err)
+	}
+	expected := []string{
+		`ID3v1 title: "譚ｱ莠ｬ" looks like UTF-8 read as CP932: "東京"`,
+		`ID3v2 TIT2: "\u0083\u008d\u0083b\u0083N" looks like CP932 read as ISO-8859-1: "ロック"`,
+		`ID3v2 TPE1: "CafÃ©" looks like UTF-8 read as ISO-8859-1: "Café"`,
+		`ID3v2 COMM: "Êèíî" looks like CP1251 read as ISO-8859-1: "Кино"`,
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("FindMojibake() = %v, expected %v", found, expected)
+	}
+	for i, m := range found {
+		if m.String() != expected[i] {
+			t.Errorf("FindMojibake()[%d] = %s, expected %s", i, m, expected[i])
+		}
+	}
+
+	v1Change, v2Change, err := PlanMojibakeRepair(path, status, found)
+	if err != nil {
+		t.Fatalf("PlanMojibakeRepair() error = %v", err)
+	}
+	if err := writeID3v2Change(v2Change, nil); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = CheckMp3FileStatus(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTagChange(newTagChange(path, status, v1Change.New), nil); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = CheckMp3FileStatus(path); err != nil {
+		t.Fatal(err)
+	}
+	if found, err = FindMojibake(path, status, candidates); err != nil || len(found) != 0 {
+		t.Errorf("FindMojibake() after repair = %v, %v", found, err)
+	}
+	if title := status.ID3v1.Fields().Title; title != "東京" {
+		t.Errorf("ID3v1 title = %q, expected %q", title, "東京")
+	}
+	fields, err := ReadID3v1FieldsFromID3v2(path, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields.Title != "ロック" || fields.Artist != "Café" || fields.Album != "Album" || fields.Comment != "Кино" {
+		t.Errorf("ID3v2 frames = %+v", fields)
+	}
+}
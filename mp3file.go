@@ -18,20 +18,157 @@ package main
 
 import (
 	"os"
-
-	"github.com/dhowden/tag"
+	"strings"
 )
 
-// CheckMp3FileStatus returns true if an MP3 file has an ID3 tag, false otherwise.
-func CheckMp3FileStatus(pathname string) (bool, error) {
+// Finding is a problem found in a tag of an MP3 file.
+type Finding struct {
+	Field string // Tag and field the finding is about, e.g. "ID3v1 track"
+	What  string
+}
+
+func (f Finding) String() string {
+	return f.Field + ": " + f.What
+}
+
+// Mp3FileStatus describes the tags found in an MP3 file.
+type Mp3FileStatus struct {
+	ID3v1      *ID3v1Tag
+	TagPlus    *TagPlus       // Enhanced tag extending the ID3v1 tag
+	ID3v2      []*ID3v2Header // Prepended tag first, then appended one
+	Tail       []TailBlock    // Tag blocks after the audio, in file order
+	Findings   []Finding
+	AudioStart int64      // Offset of the audio after the prepended ID3v2 tag
+	AudioEnd   int64      // Offset of the end of the audio before the tail tags
+	Audio      *AudioInfo // Properties of the audio, if analysed
+	Size       int64      // Size of the file
+}
+
+// HasID3v1 returns true if the file has an ID3v1 tag.
+func (s Mp3FileStatus) HasID3v1() bool {
+	return s.ID3v1 != nil
+}
+
+// HasID3v2 returns true if the file has at least one ID3v2 tag.
+func (s Mp3FileStatus) HasID3v2() bool {
+	return len(s.ID3v2) > 0
+}
+
+// Kind classifies the file as "both", "v1-only", "v2-only" or
+// "untagged".
+func (s Mp3FileStatus) Kind() string {
+	switch {
+	case s.HasID3v1() && s.HasID3v2():
+		return "both"
+	case s.HasID3v1():
+		return "v1-only"
+	case s.HasID3v2():
+		return "v2-only"
+	default:
+		return "untagged"
+	}
+}
+
+func (s Mp3FileStatus) String() string {
+	tags := make([]string, 0, 4)
+	if len(s.ID3v2) > 0 && !s.ID3v2[0].Appended {
+		tags = append(tags, s.ID3v2[0].String())
+	}
+	for _, block := range s.Tail {
+		switch block.Kind {
+		case blockID3v1:
+			tags = append(tags, s.ID3v1.String())
+		case blockID3v2:
+			tags = append(tags, s.ID3v2[len(s.ID3v2)-1].String())
+		default:
+			tags = append(tags, block.Kind)
+		}
+	}
+	if len(tags) == 0 {
+		return s.Kind()
+	}
+	return s.Kind() + ": " + strings.Join(tags, ", ")
+}
+
+// CheckMp3FileStatus reports the ID3v1 and ID3v2 tags an MP3 file has,
+// together with the problems found in them.
+func CheckMp3FileStatus(pathname string) (Mp3FileStatus, error) {
+	var status Mp3FileStatus
 	f, err1 := os.Open(pathname)
 	if err1 != nil {
-		return false, err1
+		return status, err1
 	}
 	defer f.Close()
-	_, err2 := tag.ReadID3v1Tags(f)
+	stat, err2 := f.Stat()
 	if err2 != nil {
-		return false, nil
+		return status, err2
+	}
+	size := stat.Size()
+	status.Size = size
+	h, err3 := readID3v2Header(f, size)
+	if err3 != nil {
+		return status, err3
+	}
+	var start int64
+	if h != nil {
+		status.ID3v2 = append(status.ID3v2, h)
+		start = h.TotalSize()
+	}
+	tail, err4 := readTailLayout(f, start, size)
+	if err4 != nil {
+		return status, err4
+	}
+	status.Tail = tail.Blocks
+	status.AudioStart = start
+	status.AudioEnd = tail.Start(size)
+	status.ID3v1 = tail.ID3v1
+	status.TagPlus = tail.TagPlus
+	if tail.ID3v2 != nil {
+		status.ID3v2 = append(status.ID3v2, tail.ID3v2)
+	}
+	if status.ID3v1 != nil {
+		status.Findings = append(status.Findings, status.ID3v1.validate()...)
+	}
+	if status.TagPlus != nil {
+		status.Findings = append(status.Findings, status.TagPlus.validate(status.ID3v1)...)
+	}
+	status.Findings = append(status.Findings, tail.validate()...)
+	return status, nil
+}
+
+// writeTagChange writes the ID3v1 tag planned by a change, overwriting
+// the tag the file has or appending one to the end of the file.  The
+// write is recorded in the journal first, unless the journal is nil.
+func writeTagChange(c *TagChange, j *journal) error {
+	s := fileSplice{Offset: c.Offset, Data: c.New.Encode()}
+	if c.Old != nil {
+		s.Length = id3v1TagSize
+	}
+	return writeFile(c.Path, []fileSplice{s}, j)
+}
+
+// writeID3v2Change replaces the ID3v2 tag at the start of a file with
+// the tag planned by a change, or inserts it if the file has none.  The
+// write is recorded in the journal first, unless the journal is nil.
+func writeID3v2Change(c *ID3v2Change, j *journal) error {
+	s := fileSplice{Data: c.Tag}
+	if c.Old != nil {
+		s.Length = c.Old.TotalSize()
+	}
+	return writeFile(c.Path, []fileSplice{s}, j)
+}
+
+// writeFile applies splices to a file, recording them in the journal
+// first unless the journal is nil.
+func writeFile(pathname string, splices []fileSplice, j *journal) error {
+	if j != nil {
+		e, err := newJournalEntry(pathname, splices)
+		if err != nil {
+			return err
+		}
+		if err := j.Record(e); err != nil {
+			return err
+		}
 	}
-	return true, nil
+	return writeSplices(pathname, splices)
 }
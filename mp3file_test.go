@@ -3,6 +3,8 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -78,8 +80,8 @@ func TestCheckMp3FileStatus(t *testing.T) {
 				return
 			}
 			
-			if got != tt.expected {
-				t.Errorf("CheckMp3FileStatus() = %v, expected %v", got, tt.expected)
+			if got.HasID3v1() != tt.expected {
+				t.Errorf("CheckMp3FileStatus() = %v, expected %v", got.HasID3v1(), tt.expected)
 			}
 		})
 	}
@@ -114,3 +116,93 @@ func createMockMp3FileWithoutID3v1Tag(t *testing.T, path string) {
 		t.Fatalf("Failed to create mock MP3 file without ID3v1 tag: %v", err)
 	}
 }
+
+func TestCheckMp3FileStatusID3v2(t *testing.T) {
+	dir := t.TempDir()
+	frameData := []byte{0xFF, 0xFB, 0x90, 0x44, 0x00}
+	id3v1Tag := make([]byte, 128)
+	copy(id3v1Tag, "TAG")
+
+	tests := []struct {
+		name     string
+		data     [][]byte
+		kind     string
+		versions []string
+	}{
+		{
+			name:     "ID3v2.3 only",
+			data:     [][]byte{makeID3v2Tag(3, 0, 20), frameData},
+			kind:     "v2-only",
+			versions: []string{"ID3v2.3.0 (flags 0x00, size 20)"},
+		},
+		{
+			name:     "ID3v2.2 and ID3v1",
+			data:     [][]byte{makeID3v2Tag(2, 0, 0), frameData, id3v1Tag},
+			kind:     "both",
+			versions: []string{"ID3v2.2.0 (flags 0x00, size 0)"},
+		},
+		{
+			name:     "Appended ID3v2.4 before ID3v1",
+			data:     [][]byte{frameData, makeID3v2Tag(4, id3v2FlagFooter, 30), id3v1Tag},
+			kind:     "both",
+			versions: []string{"ID3v2.4.0 (flags 0x10, size 30, appended)"},
+		},
+		{
+			name: "Prepended and appended ID3v2.4",
+			data: [][]byte{makeID3v2Tag(4, 0, 5), frameData, makeID3v2Tag(4, id3v2FlagFooter, 0)},
+			kind: "v2-only",
+			versions: []string{
+				"ID3v2.4.0 (flags 0x00, size 5)",
+				"ID3v2.4.0 (flags 0x10, size 0, appended)",
+			},
+		},
+		{
+			name: "ID3v1 followed by APEv2",
+			data: [][]byte{frameData, id3v1Tag, makeAPEv2Tag(nil, true)},
+			kind: "v1-only",
+		},
+		{
+			name: "Untagged",
+			data: [][]byte{frameData},
+			kind: "untagged",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("%d.mp3", i))
+			if err := os.WriteFile(path, bytes.Join(tt.data, nil), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := CheckMp3FileStatus(path)
+			if err != nil {
+				t.Fatalf("CheckMp3FileStatus() error = %v", err)
+			}
+			if got.Kind() != tt.kind {
+				t.Errorf("Kind() = %v, expected %v", got.Kind(), tt.kind)
+			}
+			if len(got.ID3v2) != len(tt.versions) {
+				t.Fatalf("found %d ID3v2 tags, expected %d", len(got.ID3v2), len(tt.versions))
+			}
+			for j, h := range got.ID3v2 {
+				if h.String() != tt.versions[j] {
+					t.Errorf("ID3v2[%d] = %v, expected %v", j, h, tt.versions[j])
+				}
+			}
+		})
+	}
+}
+
+// makeID3v2Tag builds an empty ID3v2 tag with the given body size,
+// including a footer when the flags ask for one.
+func makeID3v2Tag(version byte, flags byte, size int) []byte {
+	header := []byte{'I', 'D', '3', version, 0, flags,
+		byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F),
+		byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	b := append(header, make([]byte, size)...)
+	if flags&id3v2FlagFooter != 0 {
+		footer := append([]byte("3DI"), header[3:]...)
+		b = append(b, footer...)
+	}
+	return b
+}
@@ -0,0 +1,376 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// MPEG audio versions, as coded in the frame header.
+const (
+	mpeg25 = 0
+	mpeg2  = 2
+	mpeg1  = 3
+)
+
+const (
+	mpegHeaderSize = 4
+	// Maximum number of bytes skipped looking for the first frame
+	mpegSyncLimit = 64 * 1024
+)
+
+var mpegVersionNames = map[byte]string{mpeg1: "MPEG-1", mpeg2: "MPEG-2", mpeg25: "MPEG-2.5"}
+
+var mpegLayerNames = [...]string{"", "Layer I", "Layer II", "Layer III"}
+
+var mpegChannelModes = [...]string{"stereo", "joint stereo", "dual channel", "mono"}
+
+// mpegBitrates holds bitrates in kbit/s indexed by MPEG-1 or not, layer
+// and bitrate index.
+var mpegBitrates = [2][4][15]int{
+	{ // MPEG-2 and MPEG-2.5
+		{},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+	{ // MPEG-1
+		{},
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+}
+
+var mpegSampleRates = map[byte][3]int{
+	mpeg1:  {44100, 48000, 32000},
+	mpeg2:  {22050, 24000, 16000},
+	mpeg25: {11025, 12000, 8000},
+}
+
+// mpegFrameHeader is a decoded MPEG audio frame header.
+type mpegFrameHeader struct {
+	Version     byte // mpeg1, mpeg2 or mpeg25
+	Layer       int  // 1, 2 or 3
+	Protected   bool // true if a CRC-16 follows the header
+	Bitrate     int  // kbit/s
+	SampleRate  int  // Hz
+	Padding     bool
+	ChannelMode byte // Index into mpegChannelModes
+}
+
+// parseMpegFrameHeader decodes a frame header.  Free-format bitrates are
+// rejected, since the size of such frames cannot be determined from the
+// header.
+func parseMpegFrameHeader(b []byte) (mpegFrameHeader, bool) {
+	var h mpegFrameHeader
+	if len(b) < mpegHeaderSize || !isMpegAudioFrameHeader(b) {
+		return h, false
+	}
+	h.Version = b[1] >> 3 & 0x03
+	h.Layer = 4 - int(b[1]>>1&0x03)
+	h.Protected = b[1]&0x01 == 0
+	index := b[2] >> 4
+	if index == 0 {
+		return h, false
+	}
+	mpeg1Index := 0
+	if h.Version == mpeg1 {
+		mpeg1Index = 1
+	}
+	h.Bitrate = mpegBitrates[mpeg1Index][h.Layer][index]
+	h.SampleRate = mpegSampleRates[h.Version][b[2]>>2&0x03]
+	h.Padding = b[2]&0x02 != 0
+	h.ChannelMode = b[3] >> 6
+	return h, true
+}
+
+// SamplesPerFrame returns the number of samples per channel in a frame.
+func (h mpegFrameHeader) SamplesPerFrame() int {
+	switch {
+	case h.Layer == 1:
+		return 384
+	case h.Layer == 3 && h.Version != mpeg1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// FrameSize returns the size of the frame in bytes, including the
+// header.
+func (h mpegFrameHeader) FrameSize() int {
+	if h.Layer == 1 {
+		size := 12 * h.Bitrate * 1000 / h.SampleRate
+		if h.Padding {
+			size++
+		}
+		return size * 4
+	}
+	size := h.SamplesPerFrame() / 8 * h.Bitrate * 1000 / h.SampleRate
+	if h.Padding {
+		size++
+	}
+	return size
+}
+
+// SideInfoSize returns the size of the Layer III side information
+// following the header and the CRC.
+func (h mpegFrameHeader) SideInfoSize() int {
+	mono := h.ChannelMode == 3
+	switch {
+	case h.Version == mpeg1 && mono:
+		return 17
+	case h.Version == mpeg1:
+		return 32
+	case mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// compatible returns true if two headers belong to the same stream.
+func (h mpegFrameHeader) compatible(o mpegFrameHeader) bool {
+	return h.Version == o.Version && h.Layer == o.Layer && h.SampleRate == o.SampleRate
+}
+
+// AudioInfo describes the MPEG audio stream of a file.
+type AudioInfo struct {
+	Version     string // "MPEG-1", "MPEG-2" or "MPEG-2.5"
+	Layer       string // "Layer I", "Layer II" or "Layer III"
+	ChannelMode string
+	SampleRate  int    // Hz
+	VBR         bool   // true if the bitrate is variable
+	VBRHeader   string // "Xing", "Info", "VBRI" or empty
+	Bitrate     int    // Average bitrate in kbit/s
+	Frames      int
+	Duration    time.Duration
+}
+
+func (a *AudioInfo) String() string {
+	mode := "CBR"
+	if a.VBR {
+		mode = "VBR"
+	}
+	if a.VBRHeader != "" {
+		mode += " (" + a.VBRHeader + ")"
+	}
+	seconds := a.Duration.Seconds()
+	minutes := int(seconds) / 60
+	return fmt.Sprintf("%s %s, %s, %d Hz, %s %d kbps, %d frames, %d:%05.2f",
+		a.Version, a.Layer, a.ChannelMode, a.SampleRate, mode, a.Bitrate,
+		a.Frames, minutes, seconds-float64(minutes*60))
+}
+
+// vbrHeader is a Xing, Info or VBRI header in the first frame.
+type vbrHeader struct {
+	Kind   string
+	Frames int // Number of frames, 0 if unknown
+	Bytes  int // Number of bytes of the stream, 0 if unknown
+}
+
+// parseVBRHeader looks for a Xing or Info header after the side
+// information of the first frame, or for a VBRI header 32 bytes after
+// the frame header.
+func parseVBRHeader(h mpegFrameHeader, frame []byte) *vbrHeader {
+	offset := mpegHeaderSize + h.SideInfoSize()
+	if h.Protected {
+		offset += 2
+	}
+	if len(frame) >= offset+16 {
+		id := string(frame[offset : offset+4])
+		if id == "Xing" || id == "Info" {
+			v := &vbrHeader{Kind: id}
+			flags := binary.BigEndian.Uint32(frame[offset+4:])
+			p := offset + 8
+			if flags&0x01 != 0 {
+				v.Frames = int(binary.BigEndian.Uint32(frame[p:]))
+				p += 4
+			}
+			if flags&0x02 != 0 && len(frame) >= p+4 {
+				v.Bytes = int(binary.BigEndian.Uint32(frame[p:]))
+			}
+			return v
+		}
+	}
+	offset = mpegHeaderSize + 32
+	if len(frame) >= offset+18 && string(frame[offset:offset+4]) == "VBRI" {
+		return &vbrHeader{
+			Kind:   "VBRI",
+			Bytes:  int(binary.BigEndian.Uint32(frame[offset+10:])),
+			Frames: int(binary.BigEndian.Uint32(frame[offset+14:])),
+		}
+	}
+	return nil
+}
+
+// mpegFrame is a frame found while walking an MPEG audio stream.
+type mpegFrame struct {
+	Offset int64
+	Header mpegFrameHeader
+}
+
+// mpegWalker walks the frames of an MPEG audio stream between two
+// offsets of a file, resynchronising on the next frame whenever the
+// stream does not continue with a valid frame header.
+type mpegWalker struct {
+	r      io.ReaderAt
+	start  int64
+	end    int64
+	buffer *bufio.Reader
+	offset int64
+	synced bool // true if the offset follows the previous frame
+	found  bool // true once a frame has been found
+}
+
+func newMpegWalker(r io.ReaderAt, start int64, end int64) *mpegWalker {
+	return &mpegWalker{
+		r:      r,
+		start:  start,
+		end:    end,
+		buffer: bufio.NewReaderSize(io.NewSectionReader(r, start, end-start), 64*1024),
+		offset: start,
+	}
+}
+
+// confirmed returns true if a frame with the header h at the offset is
+// followed by another compatible frame or ends exactly at the end of
+// the stream.
+func (w *mpegWalker) confirmed(offset int64, h mpegFrameHeader) bool {
+	next := offset + int64(h.FrameSize())
+	if next == w.end {
+		return true
+	}
+	if next+mpegHeaderSize > w.end {
+		return false
+	}
+	b := make([]byte, mpegHeaderSize)
+	if _, err := w.r.ReadAt(b, next); err != nil {
+		return false
+	}
+	h2, ok := parseMpegFrameHeader(b)
+	return ok && h.compatible(h2)
+}
+
+// Next returns the next frame and its bytes, together with the number
+// of bytes skipped to find it.  At the end of the stream, it returns
+// io.EOF with the number of bytes left after the last frame.  A frame
+// extending beyond the end of the stream is returned with the available
+// bytes only.  A frame right after the previous one is accepted as it
+// is, while a frame found by resynchronisation has to be followed by
+// another frame.
+func (w *mpegWalker) Next() (f mpegFrame, data []byte, skipped int64, err error) {
+	for w.offset+mpegHeaderSize <= w.end {
+		if !w.found && skipped >= mpegSyncLimit {
+			break
+		}
+		b, err := w.buffer.Peek(mpegHeaderSize)
+		if err != nil {
+			return f, nil, skipped, err
+		}
+		if h, ok := parseMpegFrameHeader(b); ok && (w.synced || w.confirmed(w.offset, h)) {
+			size := int64(h.FrameSize())
+			if w.offset+size > w.end {
+				size = w.end - w.offset
+			}
+			data = make([]byte, size)
+			if _, err := io.ReadFull(w.buffer, data); err != nil {
+				return f, nil, skipped, err
+			}
+			f = mpegFrame{w.offset, h}
+			w.offset += size
+			w.synced = true
+			w.found = true
+			return f, data, skipped, nil
+		}
+		if _, err := w.buffer.Discard(1); err != nil {
+			return f, nil, skipped, err
+		}
+		w.offset++
+		w.synced = false
+		skipped++
+	}
+	skipped += w.end - w.offset
+	w.offset = w.end
+	return f, nil, skipped, io.EOF
+}
+
+// AnalyseMpegAudio reports the properties of the MPEG audio stream of
+// an MP3 file, between its ID3v2 tag and the tag blocks at its end.  The
+// number of frames and the duration are taken from a Xing, Info or VBRI
+// header if there is one, otherwise every frame is counted.  It returns
+// nil if no frame is found.
+func AnalyseMpegAudio(pathname string, status Mp3FileStatus) (*AudioInfo, error) {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	w := newMpegWalker(f, status.AudioStart, status.AudioEnd)
+	first, data, _, err := w.Next()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	h := first.Header
+	info := &AudioInfo{
+		Version:     mpegVersionNames[h.Version],
+		Layer:       mpegLayerNames[h.Layer],
+		ChannelMode: mpegChannelModes[h.ChannelMode],
+		SampleRate:  h.SampleRate,
+	}
+	audioBytes := status.AudioEnd - first.Offset
+	if v := parseVBRHeader(h, data); v != nil && v.Frames > 0 {
+		info.VBRHeader = v.Kind
+		info.VBR = v.Kind != "Info"
+		info.Frames = v.Frames
+		audioBytes -= int64(len(data))
+		if v.Bytes > 0 {
+			audioBytes = int64(v.Bytes) - int64(len(data))
+		}
+	} else {
+		info.Frames = 1
+		for {
+			frame, _, _, err := w.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if frame.Header.Bitrate != h.Bitrate {
+				info.VBR = true
+			}
+			info.Frames++
+		}
+	}
+	samples := int64(info.Frames) * int64(h.SamplesPerFrame())
+	info.Duration = time.Duration(samples * int64(time.Second) / int64(h.SampleRate))
+	if !info.VBR {
+		info.Bitrate = h.Bitrate
+	} else if info.Duration > 0 {
+		info.Bitrate = int(math.Round(float64(audioBytes*8) / info.Duration.Seconds() / 1000))
+	}
+	return info, nil
+}
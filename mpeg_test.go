@@ -0,0 +1,168 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makeMpegFrame builds a silent frame with the given 4-byte header.
+func makeMpegFrame(header ...byte) []byte {
+	h, ok := parseMpegFrameHeader(header)
+	if !ok {
+		panic("invalid frame header")
+	}
+	frame := make([]byte, h.FrameSize())
+	copy(frame, header)
+	return frame
+}
+
+// makeMpegFrames repeats a frame n times.
+func makeMpegFrames(n int, header ...byte) []byte {
+	return bytes.Repeat(makeMpegFrame(header...), n)
+}
+
+// makeXingFrame builds an MPEG-1 Layer III frame carrying a Xing or
+// Info header with the number of frames and bytes.
+func makeXingFrame(id string, frames int, size int) []byte {
+	frame := makeMpegFrame(0xFF, 0xFB, 0x90, 0x44)
+	copy(frame[36:], id)
+	binary.BigEndian.PutUint32(frame[40:], 0x03)
+	binary.BigEndian.PutUint32(frame[44:], uint32(frames))
+	binary.BigEndian.PutUint32(frame[48:], uint32(size))
+	return frame
+}
+
+func TestParseMpegFrameHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     []byte
+		ok         bool
+		bitrate    int
+		sampleRate int
+		size       int
+		samples    int
+	}{
+		{"MPEG-1 Layer III 128 kbps", []byte{0xFF, 0xFB, 0x90, 0x44}, true, 128, 44100, 417, 1152},
+		{"MPEG-1 Layer III padded", []byte{0xFF, 0xFB, 0x92, 0x44}, true, 128, 44100, 418, 1152},
+		{"MPEG-2 Layer III 64 kbps", []byte{0xFF, 0xF3, 0x80, 0xC4}, true, 64, 22050, 208, 576},
+		{"MPEG-2.5 Layer III 32 kbps", []byte{0xFF, 0xE3, 0x44, 0xC4}, true, 32, 12000, 192, 576},
+		{"MPEG-1 Layer II 192 kbps", []byte{0xFF, 0xFD, 0xA4, 0x00}, true, 192, 48000, 576, 1152},
+		{"MPEG-1 Layer I 384 kbps", []byte{0xFF, 0xFF, 0xC0, 0x00}, true, 384, 44100, 416, 384},
+		{"Free format", []byte{0xFF, 0xFB, 0x00, 0x44}, false, 0, 0, 0, 0},
+		{"No sync", []byte{0xFF, 0x1B, 0x90, 0x44}, false, 0, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, ok := parseMpegFrameHeader(tt.header)
+			if ok != tt.ok {
+				t.Fatalf("parseMpegFrameHeader() ok = %v, expected %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if h.Bitrate != tt.bitrate || h.SampleRate != tt.sampleRate {
+				t.Errorf("bitrate, sample rate = %d, %d, expected %d, %d",
+					h.Bitrate, h.SampleRate, tt.bitrate, tt.sampleRate)
+			}
+			if h.FrameSize() != tt.size {
+				t.Errorf("FrameSize() = %d, expected %d", h.FrameSize(), tt.size)
+			}
+			if h.SamplesPerFrame() != tt.samples {
+				t.Errorf("SamplesPerFrame() = %d, expected %d", h.SamplesPerFrame(), tt.samples)
+			}
+		})
+	}
+}
+
+func TestAnalyseMpegAudio(t *testing.T) {
+	dir := t.TempDir()
+	cbr := makeMpegFrames(100, 0xFF, 0xFB, 0x90, 0x44)
+	vbr := append(makeMpegFrames(50, 0xFF, 0xFB, 0x90, 0x44), makeMpegFrames(50, 0xFF, 0xFB, 0xB0, 0x44)...)
+	xing := append(makeXingFrame("Xing", 100, 417+len(vbr)), vbr...)
+	info := append(makeXingFrame("Info", 100, 101*417), cbr...)
+
+	tests := []struct {
+		name     string
+		data     [][]byte
+		expected string
+	}{
+		{
+			name:     "CBR without header",
+			data:     [][]byte{cbr},
+			expected: "MPEG-1 Layer III, joint stereo, 44100 Hz, CBR 128 kbps, 100 frames, 0:02.61",
+		},
+		{
+			name:     "CBR after ID3v2 and junk, before ID3v1",
+			data:     [][]byte{makeID3v2Tag(3, 0, 100), []byte("junk"), cbr, makeID3v1Tag(nil)},
+			expected: "MPEG-1 Layer III, joint stereo, 44100 Hz, CBR 128 kbps, 100 frames, 0:02.61",
+		},
+		{
+			name:     "VBR without header",
+			data:     [][]byte{vbr},
+			expected: "MPEG-1 Layer III, joint stereo, 44100 Hz, VBR 160 kbps, 100 frames, 0:02.61",
+		},
+		{
+			name:     "VBR with Xing header",
+			data:     [][]byte{xing},
+			expected: "MPEG-1 Layer III, joint stereo, 44100 Hz, VBR (Xing) 160 kbps, 100 frames, 0:02.61",
+		},
+		{
+			name:     "CBR with Info header",
+			data:     [][]byte{info},
+			expected: "MPEG-1 Layer III, joint stereo, 44100 Hz, CBR (Info) 128 kbps, 100 frames, 0:02.61",
+		},
+		{
+			name:     "MPEG-2.5 mono",
+			data:     [][]byte{makeMpegFrames(20, 0xFF, 0xE3, 0x44, 0xC4)},
+			expected: "MPEG-2.5 Layer III, mono, 12000 Hz, CBR 32 kbps, 20 frames, 0:00.96",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "test.mp3")
+			if err := os.WriteFile(path, bytes.Join(tt.data, nil), 0644); err != nil {
+				t.Fatal(err)
+			}
+			status, err := CheckMp3FileStatus(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := AnalyseMpegAudio(path, status)
+			if err != nil {
+				t.Fatalf("AnalyseMpegAudio() error = %v", err)
+			}
+			if got == nil || got.String() != tt.expected {
+				t.Errorf("AnalyseMpegAudio() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAnalyseMpegAudioNoFrame(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	if err := os.WriteFile(path, append(makeID3v2Tag(3, 0, 10), "no audio"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, _ := CheckMp3FileStatus(path)
+	got, err := AnalyseMpegAudio(path, status)
+	if err != nil || got != nil {
+		t.Errorf("AnalyseMpegAudio() = %v, %v, expected nil", got, err)
+	}
+}
+
+func TestAudioInfoDuration(t *testing.T) {
+	a := &AudioInfo{Version: "MPEG-1", Layer: "Layer III", ChannelMode: "stereo",
+		SampleRate: 44100, Bitrate: 320, Frames: 9000, Duration: 235*time.Second + 100*time.Millisecond}
+	expected := "MPEG-1 Layer III, stereo, 44100 Hz, CBR 320 kbps, 9000 frames, 3:55.10"
+	if a.String() != expected {
+		t.Errorf("String() = %v, expected %v", a, expected)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// normalizeRules is a flag.Value selecting how --normalize normalizes
+// the text of tags.
+type normalizeRules map[string]bool
+
+// Rules of normalizeRules, in the order they are applied
+var normalizeRuleNames = []string{"kana", "alnum", "nfc", "nfkc", "space"}
+
+func (r normalizeRules) String() string {
+	var names []string
+	for _, name := range normalizeRuleNames {
+		if r[name] {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Set selects the rules by one or more of "nfc", "nfkc", "kana",
+// "alnum", "space", "all" and "none" separated with commas.  "all"
+// selects every rule but "nfkc", which folds more characters than
+// tags usually want.
+func (r normalizeRules) Set(value string) error {
+	for k := range r {
+		delete(r, k)
+	}
+	for _, name := range strings.Split(value, ",") {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "all":
+			for _, rule := range normalizeRuleNames {
+				r[rule] = rule != "nfkc"
+			}
+		case "none":
+		case "nfc", "nfkc", "kana", "alnum", "space":
+			r[name] = true
+		default:
+			return fmt.Errorf("Unsupported normalization rule: %s", name)
+		}
+	}
+	return nil
+}
+
+// isHalfwidthKana reports whether r is a half-width katakana or
+// half-width Japanese punctuation.
+func isHalfwidthKana(r rune) bool {
+	return r >= 0xFF61 && r <= 0xFF9F
+}
+
+// isFullwidthAlnum reports whether r is a full-width digit or Latin
+// letter.
+func isFullwidthAlnum(r rune) bool {
+	return r >= 0xFF10 && r <= 0xFF19 || r >= 0xFF21 && r <= 0xFF3A || r >= 0xFF41 && r <= 0xFF5A
+}
+
+// Sound marks of half-width katakana as combining characters, which
+// width.Widen maps to spacing ones
+var combiningSoundMarks = strings.NewReplacer("\uff9e", "\u3099", "\uff9f", "\u309a")
+
+// Combining sound marks left over by NFC, as spacing ones
+var spacingSoundMarks = strings.NewReplacer("\u3099", "\u309b", "\u309a", "\u309c")
+
+// widenKana replaces half-width katakana with full-width ones,
+// combining a kana and the voiced sound mark following it into one
+// character.
+func widenKana(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexFunc(s, isHalfwidthKana)
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		j := strings.IndexFunc(s, func(r rune) bool { return !isHalfwidthKana(r) })
+		if j < 0 {
+			j = len(s)
+		}
+		kana := width.Widen.String(combiningSoundMarks.Replace(s[:j]))
+		b.WriteString(spacingSoundMarks.Replace(norm.NFC.String(kana)))
+		s = s[j:]
+	}
+	return b.String()
+}
+
+// narrowAlnum replaces full-width digits and Latin letters with ASCII
+// ones, leaving alone the other full-width characters.
+func narrowAlnum(s string) string {
+	return strings.Map(func(r rune) rune {
+		if isFullwidthAlnum(r) {
+			return r - 0xFF10 + '0'
+		}
+		return r
+	}, s)
+}
+
+// normalizeText normalizes each of the null-separated values of text
+// by the rules: "kana" widens half-width katakana, "alnum" narrows
+// full-width digits and letters, "nfc" and "nfkc" compose the text to
+// the Unicode normalization forms, and "space" trims white space and
+// collapses runs of it, including ideographic spaces, to one space.
+func normalizeText(s string, rules normalizeRules) string {
+	values := strings.Split(s, "\x00")
+	for i, v := range values {
+		if rules["kana"] {
+			v = widenKana(v)
+		}
+		if rules["alnum"] {
+			v = narrowAlnum(v)
+		}
+		switch {
+		case rules["nfkc"]:
+			v = norm.NFKC.String(v)
+		case rules["nfc"]:
+			v = norm.NFC.String(v)
+		}
+		if rules["space"] {
+			v = strings.Join(strings.FieldsFunc(v, unicode.IsSpace), " ")
+		}
+		values[i] = v
+	}
+	return strings.Join(values, "\x00")
+}
+
+// Unnormalized is a text field of a tag changed by normalization.
+type Unnormalized struct {
+	Tag        string    // "ID3v1" or "ID3v2"
+	Field      string    // Name of the ID3v1 field, or ID of the ID3v2 frame
+	Text       string    // Text as read
+	Normalized string    // Text normalized
+	frame      id3v2Text // Frame of an ID3v2 field
+}
+
+func (u Unnormalized) String() string {
+	return fmt.Sprintf("%s %s: %q is not normalized: %q", u.Tag, u.Field, u.Text, u.Normalized)
+}
+
+// FindUnnormalized returns the text fields of the ID3v1 tag and the
+// text and comment frames of the ID3v2 tag of a file changed by
+// normalization with the rules.
+func FindUnnormalized(pathname string, status Mp3FileStatus, rules normalizeRules) ([]Unnormalized, error) {
+	var found []Unnormalized
+	if t := status.ID3v1; t != nil {
+		for _, field := range t.fields() {
+			text := id3v1Codepage.Decode(trimID3v1String(t.Raw[field.Start:field.End]))
+			if s := normalizeText(text, rules); s != text {
+				found = append(found, Unnormalized{Tag: "ID3v1", Field: field.Name, Text: text, Normalized: s})
+			}
+		}
+	}
+	texts, err := readID3v2Texts(pathname, status)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range texts {
+		if s := normalizeText(t.Text, rules); s != t.Text {
+			found = append(found, Unnormalized{Tag: "ID3v2", Field: t.ID, Text: t.Text, Normalized: s, frame: t})
+		}
+	}
+	return found, nil
+}
+
+// PlanNormalization plans rewriting the fields found by
+// FindUnnormalized with their normalized text.
+func PlanNormalization(pathname string, status Mp3FileStatus, found []Unnormalized) (*TagChange, *ID3v2Change, error) {
+	var texts []fieldText
+	for _, u := range found {
+		texts = append(texts, fieldText{u.Tag, u.Field, u.Normalized, u.frame})
+	}
+	return planTextChanges(pathname, status, texts, "normalized")
+}
+
+// checkNormalization prints the text fields of a file not normalized
+// by the rules given by --normalize, and with --fix-normalize rewrites
+// them, returning the status of the file.
+func checkNormalization(pathname string, status Mp3FileStatus) (Mp3FileStatus, error) {
+	found, err := FindUnnormalized(pathname, status, normalization)
+	if err != nil || len(found) == 0 {
+		return status, err
+	}
+	fmt.Printf("%s: not normalized\n", pathname)
+	for _, u := range found {
+		fmt.Printf("\t%s\n", u)
+	}
+	if !*fixNormalizeFlag {
+		return status, nil
+	}
+	v1Change, v2Change, err := PlanNormalization(pathname, status, found)
+	if err != nil {
+		return status, err
+	}
+	return applyTagChanges(v1Change, v2Change, status)
+}
@@ -0,0 +1,125 @@
+// +build unittest
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeRules(t *testing.T) {
+	for _, tt := range []struct {
+		value    string
+		expected string
+	}{
+		{"all", "kana,alnum,nfc,space"},
+		{"NFKC, space", "nfkc,space"},
+		{"none", ""},
+	} {
+		r := normalizeRules{}
+		if err := r.Set(tt.value); err != nil || r.String() != tt.expected {
+			t.Errorf("Set(%q) = %q, %v, expected %q", tt.value, r, err, tt.expected)
+		}
+	}
+	if err := (normalizeRules{}).Set("nfd"); err == nil {
+		t.Error("Set(\"nfd\") succeeded, expected an error")
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	all := normalizeRules{}
+	all.Set("all")
+	for _, tt := range []struct {
+		text     string
+		rules    string
+		expected string
+	}{
+		{"Café", "nfc", "Café"},
+		{"Café", "nfc", "Café"},
+		{"ｶﾞｷﾞﾊﾟ ｱ｡", "kana", "ガギパ ア。"},
+		{"ｶﾞｷﾞ", "nfc", "ｶﾞｷﾞ"},
+		{"ﾞｱﾞ", "kana", "゛ア゛"},
+		{"ＡＢＣ　１２３！", "alnum", "ABC　123！"},
+		{"ＡＢＣ　１２３！", "nfkc", "ABC 123!"},
+		{"ﾊﾟﾌｭｰﾑ", "nfkc", "パフューム"},
+		{"  The   Band\t", "space", "The Band"},
+		{"東京　事変", "space", "東京 事変"},
+		{"A  \x00  B", "space", "A\x00B"},
+		{" ﾍﾟﾘｰ　ＲＯＣＫ Café ", "all", "ペリー ROCK Café"},
+	} {
+		rules := normalizeRules{}
+		rules.Set(tt.rules)
+		if got := normalizeText(tt.text, rules); got != tt.expected {
+			t.Errorf("normalizeText(%q, %s) = %q, expected %q", tt.text, tt.rules, got, tt.expected)
+		}
+	}
+}
+
+func TestFixNormalization(t *testing.T) {
+	defer func(cp *codepage) { id3v1Codepage = cp }(id3v1Codepage)
+	id3v1Codepage = codepages["cp932"]
+
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	id3v2Tag := makeID3v23TagWithFrames("TIT2", "Title", "TPE1", "  The   Band ", "TALB", "Album")
+	v1 := makeID3v1Tag(nil)
+	copy(v1[3:33], "\xb6\xde\xb7\xde\x00\x00\x00\x00")
+	copy(v1[33:63], "\x82\x60\x82\x61\x82\x62\x00\x00")
+	content := append(append(id3v2Tag, makeMpegFrames(4, 0xFF, 0xFB, 0x90, 0x44)...), v1...)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := CheckMp3FileStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := normalizeRules{}
+	rules.Set("all")
+	found, err := FindUnnormalized(path, status, rules)
+	if err != nil {
+		t.Fatalf("FindUnnormalized() error = %v", err)
+	}
+	expected := []string{
+		`ID3v1 title: "ｶﾞｷﾞ" is not normalized: "ガギ"`,
+		`ID3v1 artist: "ＡＢＣ" is not normalized: "ABC"`,
+		`ID3v2 TPE1: "  The   Band " is not normalized: "The Band"`,
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("FindUnnormalized() = %v, expected %v", found, expected)
+	}
+	for i, u := range found {
+		if u.String() != expected[i] {
+			t.Errorf("FindUnnormalized()[%d] = %s, expected %s", i, u, expected[i])
+		}
+	}
+
+	v1Change, v2Change, err := PlanNormalization(path, status, found)
+	if err != nil {
+		t.Fatalf("PlanNormalization() error = %v", err)
+	}
+	if err := writeID3v2Change(v2Change, nil); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = CheckMp3FileStatus(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTagChange(newTagChange(path, status, v1Change.New), nil); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = CheckMp3FileStatus(path); err != nil {
+		t.Fatal(err)
+	}
+	if found, err = FindUnnormalized(path, status, rules); err != nil || len(found) != 0 {
+		t.Errorf("FindUnnormalized() after fix = %v, %v", found, err)
+	}
+	if v := status.ID3v1.Fields(); v.Title != "ガギ" || v.Artist != "ABC" {
+		t.Errorf("ID3v1 fields = %+v", v)
+	}
+	fields, err := ReadID3v1FieldsFromID3v2(path, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields.Title != "Title" || fields.Artist != "The Band" || fields.Album != "Album" {
+		t.Errorf("ID3v2 frames = %+v", fields)
+	}
+}
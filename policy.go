@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tagRequirement is a single term of a policy such as "v1.1" or "v2".
+type tagRequirement struct {
+	major int // 0 for any tag, 1 for ID3v1, 2 for ID3v2
+	minor int // Minimum revision of ID3v1 or version of ID3v2, -1 for any
+}
+
+func (r tagRequirement) satisfiedBy(s Mp3FileStatus) bool {
+	switch r.major {
+	case 0:
+		return s.HasID3v1() || s.HasID3v2()
+	case 1:
+		return s.HasID3v1() && int(s.ID3v1.Revision) >= r.minor
+	default:
+		for _, h := range s.ID3v2 {
+			if int(h.Version) >= r.minor {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// tagPolicy selects which tags make a file properly tagged.  A policy is
+// written as one or more requirements joined with "+", all of which
+// must be satisfied, e.g. "v1+v2.3".
+type tagPolicy struct {
+	Expr     string
+	Failures int // Number of files that failed the policy
+	terms    []tagRequirement
+}
+
+// parseTagPolicy parses a policy expression.  Each requirement is one
+// of "any", "v1", "v1.0", "v1.1", "v2", "v2.2", "v2.3" and "v2.4".  A
+// version of ID3v2 is satisfied by the version or later.
+func parseTagPolicy(expr string) (*tagPolicy, error) {
+	p := &tagPolicy{Expr: expr}
+	for _, term := range strings.Split(expr, "+") {
+		var r tagRequirement
+		switch strings.ToLower(strings.TrimSpace(term)) {
+		case "any":
+			r = tagRequirement{0, -1}
+		case "v1", "v1.0":
+			r = tagRequirement{1, 0}
+		case "v1.1":
+			r = tagRequirement{1, 1}
+		case "v2", "v2.2":
+			r = tagRequirement{2, 2}
+		case "v2.3":
+			r = tagRequirement{2, 3}
+		case "v2.4":
+			r = tagRequirement{2, 4}
+		default:
+			return nil, fmt.Errorf("Unsupported requirement: %s", term)
+		}
+		p.terms = append(p.terms, r)
+	}
+	return p, nil
+}
+
+// Satisfied returns true if the file status meets all the requirements
+// of the policy.
+func (p *tagPolicy) Satisfied(s Mp3FileStatus) bool {
+	for _, r := range p.terms {
+		if !r.satisfiedBy(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// policyList is a flag.Value collecting the policies given by repeated
+// --require options.
+type policyList []*tagPolicy
+
+func (l *policyList) String() string {
+	exprs := make([]string, 0, len(*l))
+	for _, p := range *l {
+		exprs = append(exprs, p.Expr)
+	}
+	return strings.Join(exprs, ",")
+}
+
+func (l *policyList) Set(expr string) error {
+	p, err := parseTagPolicy(expr)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, p)
+	return nil
+}
@@ -0,0 +1,85 @@
+// +build unittest
+
+package main
+
+import (
+	"testing"
+)
+
+func TestTagPolicy(t *testing.T) {
+	v10 := &ID3v1Tag{Revision: 0}
+	v11 := &ID3v1Tag{Revision: 1}
+	v22 := &ID3v2Header{Version: 2}
+	v23 := &ID3v2Header{Version: 3}
+	v24 := &ID3v2Header{Version: 4}
+
+	tests := []struct {
+		expr     string
+		status   Mp3FileStatus
+		expected bool
+	}{
+		{"v1", Mp3FileStatus{ID3v1: v10}, true},
+		{"v1", Mp3FileStatus{ID3v2: []*ID3v2Header{v24}}, false},
+		{"v1.1", Mp3FileStatus{ID3v1: v10}, false},
+		{"v1.1", Mp3FileStatus{ID3v1: v11}, true},
+		{"v2", Mp3FileStatus{ID3v2: []*ID3v2Header{v22}}, true},
+		{"v2.3", Mp3FileStatus{ID3v2: []*ID3v2Header{v22}}, false},
+		{"v2.3", Mp3FileStatus{ID3v2: []*ID3v2Header{v24}}, true},
+		{"V2.4", Mp3FileStatus{ID3v2: []*ID3v2Header{v22, v24}}, true},
+		{"any", Mp3FileStatus{ID3v1: v10}, true},
+		{"any", Mp3FileStatus{}, false},
+		{"v1+v2", Mp3FileStatus{ID3v1: v10}, false},
+		{"v1+v2", Mp3FileStatus{ID3v1: v10, ID3v2: []*ID3v2Header{v23}}, true},
+		{"v1.1 + v2.3", Mp3FileStatus{ID3v1: v10, ID3v2: []*ID3v2Header{v23}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			p, err := parseTagPolicy(tt.expr)
+			if err != nil {
+				t.Fatalf("parseTagPolicy() error = %v", err)
+			}
+			if got := p.Satisfied(tt.status); got != tt.expected {
+				t.Errorf("Satisfied(%v) = %v, expected %v", tt.status, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseTagPolicyError(t *testing.T) {
+	for _, expr := range []string{"", "v3", "v2.5", "v1+", "id3v1"} {
+		if _, err := parseTagPolicy(expr); err == nil {
+			t.Errorf("parseTagPolicy(%q) succeeded, expected an error", expr)
+		}
+	}
+}
+
+func TestCheckPolicies(t *testing.T) {
+	saved := requireFlag
+	defer func() { requireFlag = saved }()
+	requireFlag = nil
+	if err := requireFlag.Set("v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := requireFlag.Set("v2.3"); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := []Mp3FileStatus{
+		{ID3v1: &ID3v1Tag{}},
+		{ID3v2: []*ID3v2Header{{Version: 3}}},
+		{},
+	}
+	for _, s := range statuses {
+		checkPolicies(s)
+	}
+	if requireFlag[0].Failures != 2 {
+		t.Errorf("v1 failures = %d, expected 2", requireFlag[0].Failures)
+	}
+	if requireFlag[1].Failures != 2 {
+		t.Errorf("v2.3 failures = %d, expected 2", requireFlag[1].Failures)
+	}
+	if got := requireFlag.String(); got != "v1,v2.3" {
+		t.Errorf("String() = %q, expected %q", got, "v1,v2.3")
+	}
+}
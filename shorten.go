@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// shortenRules is the set of rules shortening text too long for an
+// ID3v1 field, which is a flag.Value of comma-separated rule names.
+type shortenRules map[string]bool
+
+// Rules of shortenRules, in the order they are applied
+var shortenRuleNames = []string{"parens", "feat", "words"}
+
+func (r shortenRules) String() string {
+	var names []string
+	for _, name := range shortenRuleNames {
+		if r[name] {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Set selects the rules by one or more of "parens", "feat", "words",
+// "all" and "none" separated with commas.
+func (r shortenRules) Set(value string) error {
+	for k := range r {
+		delete(r, k)
+	}
+	for _, name := range strings.Split(value, ",") {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "all":
+			for _, rule := range shortenRuleNames {
+				r[rule] = true
+			}
+		case "none":
+		case "parens", "feat", "words":
+			r[name] = true
+		default:
+			return fmt.Errorf("Unsupported shortening rule: %s", name)
+		}
+	}
+	return nil
+}
+
+// Parenthesised and bracketed parts such as "(Remastered 2011)"
+var parenthesesPattern = regexp.MustCompile(`\s*(\([^()]*\)|\[[^\[\]]*\])`)
+
+// Credits of featured artists such as "featuring" and "Feat."
+var featuringPattern = regexp.MustCompile(`(?i)\b(featuring|feat\.)(\s|$)`)
+
+// shortenText shortens text to fit in size bytes when encoded.  Each
+// rule is applied only while the text is too long: "parens" drops
+// parenthesised parts from the end, "feat" abbreviates credits of
+// featured artists to "ft.", and "words" cuts the text at the end of a
+// word.  Text still too long is cut at the last character that fits,
+// never in the middle of the bytes of a character.
+func shortenText(s string, size int, rules shortenRules, encode func(string) []byte) string {
+	fits := func(s string) bool { return len(encode(s)) <= size }
+	if fits(s) {
+		return s
+	}
+	if rules["parens"] {
+		for !fits(s) {
+			loc := parenthesesPattern.FindAllStringIndex(s, -1)
+			if len(loc) == 0 || loc[len(loc)-1][0] == 0 {
+				break
+			}
+			last := loc[len(loc)-1]
+			s = s[:last[0]] + s[last[1]:]
+		}
+	}
+	if rules["feat"] && !fits(s) {
+		s = featuringPattern.ReplaceAllString(s, "ft.$2")
+	}
+	if rules["words"] && !fits(s) {
+		cut := cutText(s, size, encode)
+		if i := strings.LastIndexAny(cut, " \t"); i > 0 && utf8.RuneCountInString(cut[:i]) >= size/2 &&
+			!strings.HasPrefix(s[len(cut):], " ") {
+			cut = cut[:i]
+		}
+		return strings.TrimRight(cut, " ,;:-/&")
+	}
+	return cutText(s, size, encode)
+}
+
+// cutText returns the longest prefix of text fitting in size bytes when
+// encoded, cut between characters.
+func cutText(s string, size int, encode func(string) []byte) string {
+	n := 0
+	for i, r := range s {
+		n += len(encode(string(r)))
+		if n > size {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// titleShortener shortens the text fields of ID3v1 tags by rules, and
+// keeps the titles of tracks in an album distinct after shortening by
+// numbering a title that would read back as that of another track.
+type titleShortener struct {
+	Rules  shortenRules
+	Encode func(string) []byte
+	titles map[string]map[string]string // Full titles by stored title, by album
+}
+
+// newTitleShortener returns a shortener by rules encoding text in the
+// codepage of ID3v1 text.
+func newTitleShortener(rules shortenRules) *titleShortener {
+	encode := func(s string) []byte { return id3v1Codepage.Encode(s) }
+	return &titleShortener{rules, encode, make(map[string]map[string]string)}
+}
+
+// Shorten returns a copy of the fields with their text shortened to fit
+// the fields of an ID3v1.1 tag.  A title shortened to the same text as
+// a different title of the same artist and album shortened before ends
+// with "~" and the track number, or a sequence number if there is no
+// track number.
+func (s *titleShortener) Shorten(v ID3v1Fields) *ID3v1Fields {
+	title, album := v.Title, v.Artist+"\x00"+v.Album
+	titleSize := id3v1Fields[0].End - id3v1Fields[0].Start
+	commentSize := id3v1Fields[4].End - id3v1Fields[4].Start
+	if v.Track != 0 {
+		commentSize -= 2
+	}
+	v.Title = shortenText(v.Title, titleSize, s.Rules, s.Encode)
+	v.Artist = shortenText(v.Artist, id3v1Fields[1].End-id3v1Fields[1].Start, s.Rules, s.Encode)
+	v.Album = shortenText(v.Album, id3v1Fields[2].End-id3v1Fields[2].Start, s.Rules, s.Encode)
+	v.Comment = shortenText(v.Comment, commentSize, s.Rules, s.Encode)
+
+	seen := s.titles[album]
+	if seen == nil {
+		seen = make(map[string]string)
+		s.titles[album] = seen
+	}
+	n := int(v.Track)
+	for {
+		if full, ok := seen[v.Title]; !ok || full == title {
+			break
+		}
+		if n == 0 {
+			n = len(seen) + 1
+		}
+		suffix := "~" + strconv.Itoa(n)
+		v.Title = shortenText(title, titleSize-len(suffix), s.Rules, s.Encode) + suffix
+		n++
+	}
+	seen[v.Title] = title
+	return &v
+}
@@ -0,0 +1,83 @@
+// +build unittest
+
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestShortenText(t *testing.T) {
+	all := shortenRules{}
+	all.Set("all")
+	tests := []struct {
+		name     string
+		text     string
+		rules    string
+		expected string
+	}{
+		{"Fits", "Short Title", "all", "Short Title"},
+		{"No rules", "While My Guitar Gently Weeps (Remastered 2009)", "none", "While My Guitar Gently Weeps ("},
+		{"Parentheses", "While My Guitar Gently Weeps (Remastered 2009)", "parens", "While My Guitar Gently Weeps"},
+		{"Brackets", "Something in the Way [Live] (Remastered 2011)", "parens", "Something in the Way [Live]"},
+		{"Leading parentheses kept", "(I Can't Get No) Satisfaction Forever", "parens", "(I Can't Get No) Satisfaction "},
+		{"Featuring", "A Very Long Song Title featuring Someone", "feat", "A Very Long Song Title ft. Som"},
+		{"Words", "The Long and Winding Road to Somewhere", "words", "The Long and Winding Road to"},
+		{"All", "Bad Guy featuring Justin Bieber (Remix)", "all", "Bad Guy ft. Justin Bieber"},
+		{"Word longer than half", "Supercalifragilisticexpialidocious", "words", "Supercalifragilisticexpialidoc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := shortenRules{}
+			if err := rules.Set(tt.rules); err != nil {
+				t.Fatal(err)
+			}
+			if got := shortenText(tt.text, 30, rules, encodeLatin1); got != tt.expected {
+				t.Errorf("shortenText(%q) = %q, expected %q", tt.text, got, tt.expected)
+			}
+		})
+	}
+
+	utf8Bytes := func(s string) []byte { return []byte(s) }
+	if got := shortenText("日本語のタイトル", 10, all, utf8Bytes); got != "日本語" || !utf8.ValidString(got) {
+		t.Errorf("shortenText() = %q, expected %q", got, "日本語")
+	}
+	if err := all.Set("parens,bogus"); err == nil {
+		t.Error("Set() accepted an unsupported rule")
+	}
+}
+
+func TestTitleShortenerDistinct(t *testing.T) {
+	s := newTitleShortener(shortenRules{"words": true})
+	album := ID3v1Fields{Artist: "Brahms", Album: "Symphony No. 1", Genre: 32}
+	var got []string
+	for i, title := range []string{
+		"Symphony No. 1 in C Minor, Op. 68: I. Un poco sostenuto",
+		"Symphony No. 1 in C Minor, Op. 68: II. Andante sostenuto",
+		"Symphony No. 1 in C Minor, Op. 68: III. Un poco allegretto",
+		"Symphony No. 1 in C Minor, Op. 68: I. Un poco sostenuto",
+	} {
+		v := album
+		v.Title = title
+		if i < 3 {
+			v.Track = byte(i + 1)
+		}
+		got = append(got, s.Shorten(v).Title)
+	}
+	expected := []string{
+		"Symphony No. 1 in C Minor, Op.",
+		"Symphony No. 1 in C Minor~2",
+		"Symphony No. 1 in C Minor~3",
+		"Symphony No. 1 in C Minor, Op.",
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Shorten() title %d = %q, expected %q", i+1, got[i], expected[i])
+		}
+	}
+	v := album
+	v.Album, v.Title = "Symphony No. 2", "Symphony No. 1 in C Minor, Op. 68: II. Andante sostenuto"
+	if title := s.Shorten(v).Title; title != "Symphony No. 1 in C Minor, Op." {
+		t.Errorf("Shorten() title in another album = %q", title)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Containers detected by sniffing the content of a file.
+const (
+	containerMP3     = "MP3"
+	containerAAC     = "AAC"
+	containerMP4     = "MP4/M4A"
+	containerWAV     = "WAV"
+	containerRIFF    = "RIFF"
+	containerFLAC    = "FLAC"
+	containerOgg     = "Ogg"
+	containerHTML    = "HTML"
+	containerEmpty   = "empty"
+	containerUnknown = "unknown"
+)
+
+const sniffSize = 512
+
+// sniffFile classifies a file by its content.
+func sniffFile(pathname string) (string, error) {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return sniffContent(f, stat.Size())
+}
+
+// sniffContent classifies content by its leading bytes.  An ID3v2 tag
+// at the start is skipped before looking for an MPEG frame, since ID3v2
+// tags are also found in front of AAC streams.
+func sniffContent(r io.ReaderAt, size int64) (string, error) {
+	if size == 0 {
+		return containerEmpty, nil
+	}
+	var start int64
+	h, err := readID3v2Header(r, size)
+	if err != nil {
+		return "", err
+	}
+	if h != nil {
+		start = h.TotalSize()
+	}
+	b := make([]byte, sniffSize)
+	n, err := r.ReadAt(b, start)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	b = b[:n]
+	container := sniffBytes(b)
+	if container == containerUnknown && h != nil {
+		// A tag without audio, or audio not starting right after the tag
+		container = containerMP3
+	}
+	return container, nil
+}
+
+// sniffBytes classifies the leading bytes of content.
+func sniffBytes(b []byte) string {
+	switch {
+	case len(b) >= 12 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WAVE":
+		return containerWAV
+	case len(b) >= 4 && string(b[0:4]) == "RIFF":
+		return containerRIFF
+	case len(b) >= 8 && string(b[4:8]) == "ftyp":
+		return containerMP4
+	case len(b) >= 4 && string(b[0:4]) == "fLaC":
+		return containerFLAC
+	case len(b) >= 4 && string(b[0:4]) == "OggS":
+		return containerOgg
+	case isHTML(b):
+		return containerHTML
+	case len(b) >= 2 && b[0] == 0xFF && b[1]&0xF6 == 0xF0:
+		// ADTS frame: 12-bit sync word and layer bits 00
+		return containerAAC
+	case len(b) >= 4 && isMpegAudioFrameHeader(b):
+		return containerMP3
+	}
+	return containerUnknown
+}
+
+// isMpegAudioFrameHeader returns true if b starts with a valid MPEG audio
+// frame header, which has an 11-bit frame sync and no reserved values
+// in its version, layer, bitrate and sampling rate.
+func isMpegAudioFrameHeader(b []byte) bool {
+	return b[0] == 0xFF && b[1]&0xE0 == 0xE0 &&
+		b[1]>>3&0x03 != 0x01 && // MPEG version
+		b[1]>>1&0x03 != 0x00 && // Layer
+		b[2]>>4 != 0x0F && // Bitrate index
+		b[2]>>2&0x03 != 0x03 // Sampling rate index
+}
+
+// isHTML returns true if the content looks like an HTML document, such
+// as an error page saved by a broken download.
+func isHTML(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n\xEF\xBB\xBF")
+	b = bytes.ToLower(b)
+	for _, prefix := range []string{"<!doctype html", "<html", "<head", "<body"} {
+		if bytes.HasPrefix(b, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
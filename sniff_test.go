@@ -0,0 +1,99 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSniffContent(t *testing.T) {
+	frameData := []byte{0xFF, 0xFB, 0x90, 0x44, 0x00}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"MPEG frame", frameData, containerMP3},
+		{"ID3v2 and MPEG frame", append(makeID3v2Tag(3, 0, 16), frameData...), containerMP3},
+		{"ID3v2 only", makeID3v2Tag(4, 0, 16), containerMP3},
+		{"ID3v2 and ADTS", append(makeID3v2Tag(4, 0, 0), 0xFF, 0xF1, 0x50, 0x80), containerAAC},
+		{"WAV", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), containerWAV},
+		{"AVI", []byte("RIFF\x24\x00\x00\x00AVI LIST"), containerRIFF},
+		{"M4A", []byte("\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00"), containerMP4},
+		{"FLAC", []byte("fLaC\x00\x00\x00\x22"), containerFLAC},
+		{"Ogg", []byte("OggS\x00\x02"), containerOgg},
+		{"HTML", []byte("\r\n  <!DOCTYPE HTML PUBLIC>"), containerHTML},
+		{"Reserved version", []byte{0xFF, 0xEB, 0x90, 0x44}, containerUnknown},
+		{"Bad bitrate", []byte{0xFF, 0xFB, 0xF0, 0x44}, containerUnknown},
+		{"Text", []byte("Hello, world"), containerUnknown},
+		{"Empty", nil, containerEmpty},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sniffContent(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatalf("sniffContent() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("sniffContent() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetFileStatusSniff(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	mislabelled := write("download.mp3", []byte("<html><body>Not Found</body></html>"))
+	noExtension := write("track01", []byte{0xFF, 0xFB, 0x90, 0x44, 0x00})
+	wav := write("sound.wav", []byte("RIFF\x24\x00\x00\x00WAVEfmt "))
+
+	saved := *sniffFlag
+	defer func() { *sniffFlag = saved }()
+
+	tests := []struct {
+		name    string
+		path    string
+		sniff   bool
+		wantErr string
+	}{
+		{"Mislabelled HTML", mislabelled, false, "Mislabelled HTML file: " + mislabelled},
+		{"No extension without sniffing", noExtension, false, "Unsupported file type: " + noExtension},
+		{"No extension with sniffing", noExtension, true, ""},
+		{"WAV with sniffing", wav, true, "Unsupported file type: " + wav},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*sniffFlag = tt.sniff
+			err := getFileStatus(tt.path)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("getFileStatus() error = %q, expected %q", got, tt.wantErr)
+			}
+		})
+	}
+
+	*sniffFlag = true
+	_, files, err := readdir(dir)
+	if err != nil {
+		t.Fatalf("readdir() error = %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("readdir() = %v, expected download.mp3 and track01", files)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stripSelection is the set of kinds of tag blocks to strip, which is a
+// flag.Value of comma-separated selectors.
+type stripSelection map[string]bool
+
+// Kinds of tag blocks each selector of stripSelection stands for
+var stripSelectors = map[string][]string{
+	"v1":      {blockID3v1, blockTagPlus},
+	"v2":      {blockID3v2},
+	"ape":     {blockAPEv2},
+	"lyrics3": {blockLyrics3v1, blockLyrics3v2},
+}
+
+func (s stripSelection) String() string {
+	var selectors []string
+	for _, name := range []string{"v1", "v2", "ape", "lyrics3"} {
+		if s[stripSelectors[name][0]] {
+			selectors = append(selectors, name)
+		}
+	}
+	return strings.Join(selectors, ",")
+}
+
+// Set selects the kinds of blocks to strip by one or more of "v1", "v2",
+// "ape", "lyrics3" and "all" separated with commas.  The "v1" selector
+// includes the TAG+ block extending ID3v1.
+func (s stripSelection) Set(value string) error {
+	for k := range s {
+		delete(s, k)
+	}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "all" {
+			for _, kinds := range stripSelectors {
+				for _, kind := range kinds {
+					s[kind] = true
+				}
+			}
+			continue
+		}
+		kinds, ok := stripSelectors[name]
+		if !ok {
+			return fmt.Errorf("Unsupported tag type: %s", name)
+		}
+		for _, kind := range kinds {
+			s[kind] = true
+		}
+	}
+	return nil
+}
+
+// StripChange is the removal of tag blocks planned for a file.
+type StripChange struct {
+	Path   string
+	Blocks []TailBlock // Blocks to remove, in file order
+}
+
+// Reclaimed returns the number of bytes the removal reclaims.
+func (c *StripChange) Reclaimed() int64 {
+	var n int64
+	for _, b := range c.Blocks {
+		n += b.Size
+	}
+	return n
+}
+
+func (c *StripChange) String() string {
+	kinds := make([]string, len(c.Blocks))
+	for i, b := range c.Blocks {
+		kinds[i] = b.Kind
+	}
+	return fmt.Sprintf("%s stripped, %d bytes reclaimed", strings.Join(kinds, ", "), c.Reclaimed())
+}
+
+// splices returns the splices removing the blocks, where adjacent
+// blocks are joined so that blocks ending the file are removed by
+// truncating it.
+func (c *StripChange) splices() []fileSplice {
+	var splices []fileSplice
+	for _, b := range c.Blocks {
+		if n := len(splices); n > 0 && splices[n-1].Offset+splices[n-1].Length == b.Offset {
+			splices[n-1].Length += b.Size
+			continue
+		}
+		splices = append(splices, fileSplice{Offset: b.Offset, Length: b.Size})
+	}
+	return splices
+}
+
+// PlanStrip plans the removal of the selected kinds of tag blocks from a
+// file: the ID3v2 tag at its start and the blocks after the audio.
+func PlanStrip(pathname string, status Mp3FileStatus, selection stripSelection) *StripChange {
+	c := &StripChange{Path: pathname}
+	if len(status.ID3v2) > 0 && !status.ID3v2[0].Appended && selection[blockID3v2] {
+		h := status.ID3v2[0]
+		c.Blocks = append(c.Blocks, TailBlock{blockID3v2, h.Offset, h.TotalSize()})
+	}
+	for _, b := range status.Tail {
+		if selection[b.Kind] {
+			c.Blocks = append(c.Blocks, b)
+		}
+	}
+	return c
+}
+
+// StripTags removes the blocks planned by a change from its file,
+// recording the write in the journal unless it is nil.
+func StripTags(c *StripChange, j *journal) error {
+	if len(c.Blocks) == 0 {
+		return nil
+	}
+	return writeFile(c.Path, c.splices(), j)
+}
+
+// stripFile removes the tag blocks planned by a change from a file and
+// prints the bytes reclaimed.  With --dry-run it only prints them.
+func stripFile(change *StripChange) error {
+	switch {
+	case len(change.Blocks) == 0:
+		fmt.Printf("%s: nothing to strip\n", change.Path)
+	case *dryRunFlag:
+		fmt.Printf("%s: %s (dry run)\n", change.Path, change)
+	default:
+		if err := StripTags(change, runJournal); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", change.Path, change)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStripSelection(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+		wantErr  bool
+	}{
+		{"all", "v1,v2,ape,lyrics3", false},
+		{"ape", "ape", false},
+		{"Lyrics3, V1", "v1,lyrics3", false},
+		{"v3", "", true},
+	}
+	for _, tt := range tests {
+		s := stripSelection{}
+		err := s.Set(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && s.String() != tt.expected {
+			t.Errorf("Set(%q) = %s, expected %s", tt.value, s, tt.expected)
+		}
+	}
+}
+
+func TestStripTags(t *testing.T) {
+	id3v2Tag := makeID3v2Tag(3, 0, 64)
+	audio := makeMpegFrames(4, 0xFF, 0xFB, 0x90, 0x44)
+	ape := makeAPEv2Tag([]byte("items"), true)
+	lyrics := makeLyrics3v2Block("IND00002" + "10")
+	id3v1Tag := makeID3v1Tag([]byte("Comment"))
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	tests := []struct {
+		tags      string
+		expected  []byte
+		reclaimed int
+	}{
+		{"ape", join(id3v2Tag, audio, lyrics, id3v1Tag), len(ape)},
+		{"lyrics3,v1", join(id3v2Tag, audio, ape), len(lyrics) + id3v1TagSize},
+		{"v2,ape", join(audio, lyrics, id3v1Tag), len(id3v2Tag) + len(ape)},
+		{"all", audio, len(id3v2Tag) + len(ape) + len(lyrics) + id3v1TagSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tags, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.mp3")
+			if err := os.WriteFile(path, join(id3v2Tag, audio, ape, lyrics, id3v1Tag), 0644); err != nil {
+				t.Fatal(err)
+			}
+			status, err := CheckMp3FileStatus(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selection := stripSelection{}
+			selection.Set(tt.tags)
+			c := PlanStrip(path, status, selection)
+			if got := c.Reclaimed(); got != int64(tt.reclaimed) {
+				t.Errorf("Reclaimed() = %d, expected %d", got, tt.reclaimed)
+			}
+			if err := StripTags(c, nil); err != nil {
+				t.Fatalf("StripTags() error = %v", err)
+			}
+			if b, _ := os.ReadFile(path); !bytes.Equal(b, tt.expected) {
+				t.Errorf("stripped file has %d bytes, expected %d", len(b), len(tt.expected))
+			}
+		})
+	}
+}
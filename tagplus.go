@@ -0,0 +1,149 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	blockTagPlus = "TAG+"
+	tagPlusSize  = 227
+)
+
+var tagPlusSpeeds = [...]string{"unset", "slow", "medium", "fast", "hardcore"}
+
+// TagPlus is an enhanced ID3v1 tag, a 227-byte "TAG+" block placed
+// immediately before an ID3v1 tag to extend its title, artist and album
+// to 90 bytes and to add a speed, a genre string and start and end
+// times.
+type TagPlus struct {
+	Title     string
+	Artist    string
+	Album     string
+	Speed     byte
+	Genre     string
+	StartTime string
+	EndTime   string
+	Offset    int64
+}
+
+// parseTagPlus parses a 227-byte TAG+ block.
+func parseTagPlus(b []byte) (*TagPlus, bool) {
+	if len(b) < tagPlusSize || string(b[0:4]) != blockTagPlus {
+		return nil, false
+	}
+	return &TagPlus{
+		Title:     trimID3v1String(b[4:64]),
+		Artist:    trimID3v1String(b[64:124]),
+		Album:     trimID3v1String(b[124:184]),
+		Speed:     b[184],
+		Genre:     trimID3v1String(b[185:215]),
+		StartTime: trimID3v1String(b[215:221]),
+		EndTime:   trimID3v1String(b[221:227]),
+	}, true
+}
+
+// readTagPlus reads the TAG+ block ending at the offset end.
+func readTagPlus(r io.ReaderAt, end int64) (*TagPlus, error) {
+	if end < tagPlusSize {
+		return nil, nil
+	}
+	b := make([]byte, tagPlusSize)
+	if _, err := r.ReadAt(b, end-tagPlusSize); err != nil {
+		return nil, err
+	}
+	t, ok := parseTagPlus(b)
+	if !ok {
+		return nil, nil
+	}
+	t.Offset = end - tagPlusSize
+	return t, nil
+}
+
+// SpeedName returns the name of the speed.
+func (t *TagPlus) SpeedName() string {
+	if int(t.Speed) < len(tagPlusSpeeds) {
+		return tagPlusSpeeds[t.Speed]
+	}
+	return fmt.Sprintf("undefined (%d)", t.Speed)
+}
+
+// Describe returns the fields of the block, one per line.
+func (t *TagPlus) Describe() []string {
+	return []string{
+		fmt.Sprintf("title: %q", t.Title),
+		fmt.Sprintf("artist: %q", t.Artist),
+		fmt.Sprintf("album: %q", t.Album),
+		fmt.Sprintf("speed: %s", t.SpeedName()),
+		fmt.Sprintf("genre: %q", t.Genre),
+		fmt.Sprintf("start time: %q", t.StartTime),
+		fmt.Sprintf("end time: %q", t.EndTime),
+	}
+}
+
+// validate compares the block with the ID3v1 tag it extends.  A title,
+// artist or album in the block either continues a 30-byte field of the
+// ID3v1 tag or, as some taggers write, repeats the whole value; any
+// other value disagrees with the ID3v1 tag.  The genre string is
+// expected to mention the name of the ID3v1 genre.
+func (t *TagPlus) validate(v1 *ID3v1Tag) []Finding {
+	var findings []Finding
+	if int(t.Speed) >= len(tagPlusSpeeds) {
+		findings = append(findings, Finding{"TAG+ speed",
+			fmt.Sprintf("speed %d is undefined", t.Speed)})
+	}
+	if v1 == nil {
+		return findings
+	}
+	for _, f := range []struct {
+		name  string
+		value string
+		start int
+	}{
+		{"title", t.Title, 3},
+		{"artist", t.Artist, 33},
+		{"album", t.Album, 63},
+	} {
+		basic := v1.Raw[f.start : f.start+30]
+		full := basic[29] != 0 && basic[29] != ' '
+		if f.value == "" || full || strings.HasPrefix(f.value, trimID3v1String(basic)) {
+			continue
+		}
+		findings = append(findings, Finding{"TAG+ " + f.name,
+			fmt.Sprintf("%q does not extend the ID3v1 %s %q",
+				f.value, f.name, trimID3v1String(basic))})
+	}
+	genre := v1.Genre()
+	if t.Genre != "" && int(genre) < len(id3v1Genres) &&
+		!strings.Contains(strings.ToLower(t.Genre), strings.ToLower(id3v1Genres[genre])) {
+		findings = append(findings, Finding{"TAG+ genre",
+			fmt.Sprintf("%q disagrees with the ID3v1 genre %q", t.Genre, id3v1Genres[genre])})
+	}
+	return findings
+}
+
+// trimID3v1String converts the bytes of a fixed-length field to a
+// string, removing the padding of NUL and space bytes.
+func trimID3v1String(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimRight(string(b), " ")
+}
@@ -0,0 +1,123 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// makeTagPlus builds a TAG+ block with the given title, artist, album
+// and genre string.
+func makeTagPlus(title, artist, album, genre string, speed byte) []byte {
+	b := make([]byte, tagPlusSize)
+	copy(b, blockTagPlus)
+	copy(b[4:64], title)
+	copy(b[64:124], artist)
+	copy(b[124:184], album)
+	b[184] = speed
+	copy(b[185:215], genre)
+	copy(b[215:221], "000:00")
+	copy(b[221:227], "003:25")
+	return b
+}
+
+func TestParseTagPlus(t *testing.T) {
+	tag, ok := parseTagPlus(makeTagPlus("Long Title", "Artist", "Album", "Progressive Rock", 3))
+	if !ok {
+		t.Fatal("parseTagPlus() failed")
+	}
+	expected := []string{
+		`title: "Long Title"`,
+		`artist: "Artist"`,
+		`album: "Album"`,
+		"speed: fast",
+		`genre: "Progressive Rock"`,
+		`start time: "000:00"`,
+		`end time: "003:25"`,
+	}
+	got := tag.Describe()
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Describe()[%d] = %q, expected %q", i, got[i], expected[i])
+		}
+	}
+	if _, ok := parseTagPlus(makeID3v1Tag(nil)); ok {
+		t.Error("parseTagPlus() accepted an ID3v1 tag")
+	}
+}
+
+func TestTagPlusValidate(t *testing.T) {
+	v1Bytes := makeID3v1Tag([]byte("Comment"))
+	copy(v1Bytes[3:33], "A Title Exactly Thirty Bytes L")
+	v1, _ := parseID3v1Tag(v1Bytes) // Title "...L", artist "Artist", album "Album", genre Rock
+
+	tests := []struct {
+		name     string
+		block    []byte
+		expected []string
+	}{
+		{
+			name:  "Continuation of a full title",
+			block: makeTagPlus("ong and Continued", "", "", "Rock", 0),
+		},
+		{
+			name:  "Repetition of the whole value",
+			block: makeTagPlus("", "Artist and Friends", "Album", "Hard Rock", 0),
+		},
+		{
+			name:  "Disagreeing artist",
+			block: makeTagPlus("", "Somebody Else", "", "", 0),
+			expected: []string{
+				`TAG+ artist: "Somebody Else" does not extend the ID3v1 artist "Artist"`,
+			},
+		},
+		{
+			name:  "Disagreeing genre and undefined speed",
+			block: makeTagPlus("", "", "", "Jazz", 9),
+			expected: []string{
+				"TAG+ speed: speed 9 is undefined",
+				`TAG+ genre: "Jazz" disagrees with the ID3v1 genre "Rock"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, _ := parseTagPlus(tt.block)
+			got := tag.validate(v1)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("validate() = %v, expected %q", got, tt.expected)
+			}
+			for i := range got {
+				if got[i].String() != tt.expected[i] {
+					t.Errorf("validate()[%d] = %q, expected %q", i, got[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadTailLayoutWithTagPlus(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x44}, 64)
+	block := makeTagPlus("Title", "Artist", "Album", "Rock", 1)
+	id3v1Tag := makeID3v1Tag([]byte("Comment"))
+
+	data := bytes.Join([][]byte{audio, block, id3v1Tag}, nil)
+	l, err := readTailLayout(bytes.NewReader(data), 0, int64(len(data)))
+	if err != nil {
+		t.Fatalf("readTailLayout() error = %v", err)
+	}
+	if len(l.Blocks) != 2 || l.TagPlus == nil || l.TagPlus.Offset != int64(len(audio)) {
+		t.Fatalf("readTailLayout() = %v, expected TAG+ and ID3v1", l.Blocks)
+	}
+	if findings := l.validate(); len(findings) != 0 {
+		t.Errorf("validate() = %v, expected no findings", findings)
+	}
+
+	data = bytes.Join([][]byte{audio, block}, nil)
+	l, _ = readTailLayout(bytes.NewReader(data), 0, int64(len(data)))
+	if findings := l.validate(); len(findings) != 1 {
+		t.Errorf("validate() = %v, expected a finding for TAG+ without ID3v1", findings)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Kinds of tag blocks found at the end of a file.
+const (
+	blockID3v1     = "ID3v1"
+	blockID3v2     = "ID3v2"
+	blockAPEv2     = "APEv2"
+	blockLyrics3v1 = "Lyrics3v1"
+	blockLyrics3v2 = "Lyrics3v2"
+)
+
+const (
+	apeFooterSize      = 32
+	apeFlagHasHeader   = 1 << 31
+	lyrics3v1MaxSize   = 5100
+	lyrics3BeginMarker = "LYRICSBEGIN"
+)
+
+// TailBlock is a tag block found at the end of a file, after the audio.
+type TailBlock struct {
+	Kind   string
+	Offset int64 // Offset of the block from the start of the file
+	Size   int64 // Size of the block in bytes
+}
+
+func (b TailBlock) String() string {
+	return fmt.Sprintf("%s at %d (%d bytes)", b.Kind, b.Offset, b.Size)
+}
+
+// tailLayout is the list of tag blocks at the end of a file, in their
+// order in the file, together with the ID3v1, TAG+ and appended ID3v2
+// tags among them.
+type tailLayout struct {
+	Blocks  []TailBlock
+	ID3v1   *ID3v1Tag
+	TagPlus *TagPlus
+	ID3v2   *ID3v2Header
+}
+
+// Start returns the offset where the tag blocks start, that is the end
+// of the audio.
+func (l *tailLayout) Start(end int64) int64 {
+	if len(l.Blocks) == 0 {
+		return end
+	}
+	return l.Blocks[0].Offset
+}
+
+// readTailLayout walks backwards from the offset end, identifying the
+// tag blocks one after another until no more block is found or the
+// offset start is reached.  Each kind of block is identified only once.
+func readTailLayout(r io.ReaderAt, start int64, end int64) (*tailLayout, error) {
+	l := &tailLayout{}
+	seen := make(map[string]bool)
+	for end > start {
+		block, err := l.readBlock(r, start, end, seen)
+		if err != nil {
+			return nil, err
+		}
+		if block == nil {
+			break
+		}
+		seen[block.Kind] = true
+		l.Blocks = append([]TailBlock{*block}, l.Blocks...)
+		end = block.Offset
+	}
+	return l, nil
+}
+
+// readBlock identifies the tag block ending at the offset end.
+func (l *tailLayout) readBlock(r io.ReaderAt, start int64, end int64,
+	seen map[string]bool) (*TailBlock, error) {
+	if !seen[blockID3v1] && end-start >= id3v1TagSize {
+		t, err := readID3v1Tag(r, end)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			l.ID3v1 = t
+			return &TailBlock{blockID3v1, t.Offset, id3v1TagSize}, nil
+		}
+	}
+	if !seen[blockTagPlus] {
+		t, err := readTagPlus(r, end)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil && t.Offset >= start {
+			l.TagPlus = t
+			return &TailBlock{blockTagPlus, t.Offset, tagPlusSize}, nil
+		}
+	}
+	if !seen[blockAPEv2] {
+		if block, err := readAPEv2Block(r, start, end); block != nil || err != nil {
+			return block, err
+		}
+	}
+	if !seen[blockLyrics3v1] && !seen[blockLyrics3v2] {
+		if block, err := readLyrics3Block(r, start, end); block != nil || err != nil {
+			return block, err
+		}
+	}
+	if !seen[blockID3v2] {
+		h, err := readAppendedID3v2Header(r, end)
+		if err != nil {
+			return nil, err
+		}
+		if h != nil && h.Offset >= start {
+			l.ID3v2 = h
+			return &TailBlock{blockID3v2, h.Offset, h.TotalSize()}, nil
+		}
+	}
+	return nil, nil
+}
+
+// readAPEv2Block identifies an APEv2 tag by its footer ending at the
+// offset end.  The tag may or may not have a header.
+func readAPEv2Block(r io.ReaderAt, start int64, end int64) (*TailBlock, error) {
+	if end-start < apeFooterSize {
+		return nil, nil
+	}
+	b := make([]byte, apeFooterSize)
+	if _, err := r.ReadAt(b, end-apeFooterSize); err != nil {
+		return nil, err
+	}
+	if string(b[0:8]) != "APETAGEX" {
+		return nil, nil
+	}
+	size := int64(binary.LittleEndian.Uint32(b[12:16]))
+	if binary.LittleEndian.Uint32(b[20:24])&apeFlagHasHeader != 0 {
+		size += apeFooterSize
+	}
+	if size < apeFooterSize || end-size < start {
+		return nil, nil
+	}
+	return &TailBlock{blockAPEv2, end - size, size}, nil
+}
+
+// readLyrics3Block identifies a Lyrics3v2 block by its size and
+// "LYRICS200" marker, or a Lyrics3v1 block by searching its beginning
+// marker backwards from its "LYRICSEND" marker.
+func readLyrics3Block(r io.ReaderAt, start int64, end int64) (*TailBlock, error) {
+	if end-start < 15 {
+		return nil, nil
+	}
+	b := make([]byte, 15)
+	if _, err := r.ReadAt(b, end-15); err != nil {
+		return nil, err
+	}
+	switch {
+	case string(b[6:]) == "LYRICS200":
+		// The size is six digits counting the block up to itself
+		if len(bytes.Trim(b[0:6], "0123456789")) > 0 {
+			return nil, nil
+		}
+		n, _ := strconv.ParseInt(string(b[0:6]), 10, 64)
+		size := n + 15
+		if n < int64(len(lyrics3BeginMarker)) || end-size < start {
+			return nil, nil
+		}
+		marker := make([]byte, len(lyrics3BeginMarker))
+		if _, err := r.ReadAt(marker, end-size); err != nil {
+			return nil, err
+		}
+		if string(marker) != lyrics3BeginMarker {
+			return nil, nil
+		}
+		return &TailBlock{blockLyrics3v2, end - size, size}, nil
+	case string(b[6:]) == "LYRICSEND":
+		from := end - 9 - lyrics3v1MaxSize - int64(len(lyrics3BeginMarker))
+		if from < start {
+			from = start
+		}
+		window := make([]byte, end-from)
+		if _, err := r.ReadAt(window, from); err != nil {
+			return nil, err
+		}
+		i := bytes.LastIndex(window, []byte(lyrics3BeginMarker))
+		if i < 0 {
+			return nil, nil
+		}
+		return &TailBlock{blockLyrics3v1, from + int64(i), end - from - int64(i)}, nil
+	}
+	return nil, nil
+}
+
+// validate reports layouts that devices reading the last 128 bytes of a
+// file for an ID3v1 tag cannot cope with: an ID3v1 tag followed by other
+// blocks and a Lyrics3 or TAG+ block not immediately followed by an
+// ID3v1 tag.
+func (l *tailLayout) validate() []Finding {
+	var findings []Finding
+	for i, block := range l.Blocks {
+		var next string
+		if i+1 < len(l.Blocks) {
+			next = l.Blocks[i+1].Kind
+		}
+		switch {
+		case block.Kind == blockID3v1 && next != "":
+			findings = append(findings, Finding{"layout",
+				fmt.Sprintf("ID3v1 tag at %d is followed by %s, "+
+					"so ID3v1-only devices cannot find it", block.Offset, next)})
+		case (block.Kind == blockLyrics3v1 || block.Kind == blockLyrics3v2 ||
+			block.Kind == blockTagPlus) && next != blockID3v1:
+			findings = append(findings, Finding{"layout",
+				fmt.Sprintf("%s block at %d is not immediately followed by an ID3v1 tag",
+					block.Kind, block.Offset)})
+		}
+	}
+	return findings
+}
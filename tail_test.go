@@ -0,0 +1,111 @@
+// +build unittest
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// makeAPEv2Tag builds an APEv2 tag with the given item bytes, with or
+// without a header.
+func makeAPEv2Tag(items []byte, withHeader bool) []byte {
+	block := func(flags uint32) []byte {
+		b := make([]byte, apeFooterSize)
+		copy(b, "APETAGEX")
+		binary.LittleEndian.PutUint32(b[8:12], 2000)
+		binary.LittleEndian.PutUint32(b[12:16], uint32(len(items)+apeFooterSize))
+		binary.LittleEndian.PutUint32(b[20:24], flags)
+		return b
+	}
+	var flags uint32
+	var b []byte
+	if withHeader {
+		flags = apeFlagHasHeader
+		b = append(b, block(flags|1<<29)...)
+	}
+	b = append(b, items...)
+	return append(b, block(flags)...)
+}
+
+// makeLyrics3v2Block builds a Lyrics3v2 block with the given fields.
+func makeLyrics3v2Block(fields string) []byte {
+	body := lyrics3BeginMarker + fields
+	return []byte(fmt.Sprintf("%s%06dLYRICS200", body, len(body)))
+}
+
+func TestReadTailLayout(t *testing.T) {
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x44}, 64)
+	id3v1Tag := makeID3v1Tag([]byte("Comment"))
+	ape := makeAPEv2Tag([]byte("items"), false)
+	apeWithHeader := makeAPEv2Tag([]byte("items"), true)
+	lyrics3v2 := makeLyrics3v2Block("LYR00005Hello")
+	lyrics3v1 := []byte(lyrics3BeginMarker + "Hello" + "LYRICSEND")
+
+	tests := []struct {
+		name     string
+		data     [][]byte
+		kinds    []string
+		findings int
+	}{
+		{"ID3v1 only", [][]byte{audio, id3v1Tag}, []string{"ID3v1"}, 0},
+		{"APEv2 before ID3v1", [][]byte{audio, ape, id3v1Tag}, []string{"APEv2", "ID3v1"}, 0},
+		{"APEv2 with header", [][]byte{audio, apeWithHeader}, []string{"APEv2"}, 0},
+		{"APEv2 after ID3v1", [][]byte{audio, id3v1Tag, apeWithHeader}, []string{"ID3v1", "APEv2"}, 1},
+		{"Lyrics3v2 before ID3v1", [][]byte{audio, lyrics3v2, id3v1Tag}, []string{"Lyrics3v2", "ID3v1"}, 0},
+		{"Lyrics3v1 before ID3v1", [][]byte{audio, lyrics3v1, id3v1Tag}, []string{"Lyrics3v1", "ID3v1"}, 0},
+		{"Lyrics3v2 without ID3v1", [][]byte{audio, lyrics3v2}, []string{"Lyrics3v2"}, 1},
+		{
+			"APEv2, Lyrics3v2 and ID3v1",
+			[][]byte{audio, ape, lyrics3v2, id3v1Tag},
+			[]string{"APEv2", "Lyrics3v2", "ID3v1"}, 0,
+		},
+		{
+			"Lyrics3v2 after ID3v1",
+			[][]byte{audio, id3v1Tag, lyrics3v2},
+			[]string{"ID3v1", "Lyrics3v2"}, 2,
+		},
+		{
+			"Appended ID3v2.4 and APEv2",
+			[][]byte{audio, makeID3v2Tag(4, id3v2FlagFooter, 10), ape, id3v1Tag},
+			[]string{"ID3v2", "APEv2", "ID3v1"}, 0,
+		},
+		{"No tags", [][]byte{audio}, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Join(tt.data, nil)
+			l, err := readTailLayout(bytes.NewReader(data), 0, int64(len(data)))
+			if err != nil {
+				t.Fatalf("readTailLayout() error = %v", err)
+			}
+			if len(l.Blocks) != len(tt.kinds) {
+				t.Fatalf("readTailLayout() = %v, expected %v", l.Blocks, tt.kinds)
+			}
+			for i, block := range l.Blocks {
+				if block.Kind != tt.kinds[i] {
+					t.Errorf("block %d = %v, expected %v", i, block.Kind, tt.kinds[i])
+				}
+			}
+			if len(l.Blocks) > 0 && l.Start(0) != int64(len(audio)) {
+				t.Errorf("Start() = %v, expected %v", l.Start(0), len(audio))
+			}
+			if findings := l.validate(); len(findings) != tt.findings {
+				t.Errorf("validate() = %v, expected %d findings", findings, tt.findings)
+			}
+		})
+	}
+}
+
+func TestReadLyrics3BlockInvalidSize(t *testing.T) {
+	for _, size := range []string{"-99999", "+00005", "000005", " 00005"} {
+		data := []byte(lyrics3BeginMarker + "Hello" + size + "LYRICS200")
+		block, err := readLyrics3Block(bytes.NewReader(data), 0, int64(len(data)))
+		if block != nil || err != nil {
+			t.Errorf("readLyrics3Block() = %v, %v for size %q, expected no block", block, err, size)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// Romanisation of hiragana in the Hepburn system, with the syllables
+// of a kana followed by a small kana.  Katakana are read as hiragana.
+var kanaRomaji = map[string]string{
+	"あ": "a", "い": "i", "う": "u", "え": "e", "お": "o",
+	"か": "ka", "き": "ki", "く": "ku", "け": "ke", "こ": "ko",
+	"さ": "sa", "し": "shi", "す": "su", "せ": "se", "そ": "so",
+	"た": "ta", "ち": "chi", "つ": "tsu", "て": "te", "と": "to",
+	"な": "na", "に": "ni", "ぬ": "nu", "ね": "ne", "の": "no",
+	"は": "ha", "ひ": "hi", "ふ": "fu", "へ": "he", "ほ": "ho",
+	"ま": "ma", "み": "mi", "む": "mu", "め": "me", "も": "mo",
+	"や": "ya", "ゆ": "yu", "よ": "yo",
+	"ら": "ra", "り": "ri", "る": "ru", "れ": "re", "ろ": "ro",
+	"わ": "wa", "ゐ": "i", "ゑ": "e", "を": "o", "ん": "n",
+	"が": "ga", "ぎ": "gi", "ぐ": "gu", "げ": "ge", "ご": "go",
+	"ざ": "za", "じ": "ji", "ず": "zu", "ぜ": "ze", "ぞ": "zo",
+	"だ": "da", "ぢ": "ji", "づ": "zu", "で": "de", "ど": "do",
+	"ば": "ba", "び": "bi", "ぶ": "bu", "べ": "be", "ぼ": "bo",
+	"ぱ": "pa", "ぴ": "pi", "ぷ": "pu", "ぺ": "pe", "ぽ": "po",
+	"ゔ": "vu",
+	"ぁ": "a", "ぃ": "i", "ぅ": "u", "ぇ": "e", "ぉ": "o",
+	"ゃ": "ya", "ゅ": "yu", "ょ": "yo", "ゎ": "wa",
+	"きゃ": "kya", "きゅ": "kyu", "きょ": "kyo",
+	"しゃ": "sha", "しゅ": "shu", "しぇ": "she", "しょ": "sho",
+	"ちゃ": "cha", "ちゅ": "chu", "ちぇ": "che", "ちょ": "cho",
+	"にゃ": "nya", "にゅ": "nyu", "にょ": "nyo",
+	"ひゃ": "hya", "ひゅ": "hyu", "ひょ": "hyo",
+	"みゃ": "mya", "みゅ": "myu", "みょ": "myo",
+	"りゃ": "rya", "りゅ": "ryu", "りょ": "ryo",
+	"ぎゃ": "gya", "ぎゅ": "gyu", "ぎょ": "gyo",
+	"じゃ": "ja", "じゅ": "ju", "じぇ": "je", "じょ": "jo",
+	"びゃ": "bya", "びゅ": "byu", "びょ": "byo",
+	"ぴゃ": "pya", "ぴゅ": "pyu", "ぴょ": "pyo",
+	"ふぁ": "fa", "ふぃ": "fi", "ふぇ": "fe", "ふぉ": "fo",
+	"てぃ": "ti", "でぃ": "di", "とぅ": "tu", "どぅ": "du",
+	"うぃ": "wi", "うぇ": "we", "うぉ": "wo",
+	"ゔぁ": "va", "ゔぃ": "vi", "ゔぇ": "ve", "ゔぉ": "vo",
+	"つぁ": "tsa", "つぃ": "tsi", "つぇ": "tse", "つぉ": "tso",
+	"、": ", ", "。": ". ", "・": " ", "「": "\"", "」": "\"", "『": "\"", "』": "\"",
+	"〜": "~", "～": "~",
+}
+
+// Romanisation of the Cyrillic alphabets of Russian, Ukrainian and
+// Belarusian, by lower case letter
+var cyrillicLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+}
+
+// Romanisation of the Greek alphabet, by lower case letter without
+// accents
+var greekLatin = map[rune]string{
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Latin letters without a decomposition to a letter and accents
+var latinLetters = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d",
+	'ð': "d", 'þ': "th", 'ı': "i",
+}
+
+// transliterator converts text to ASCII letters for devices showing
+// nothing else, after replacing the text found in a dictionary of
+// overrides.
+type transliterator struct {
+	overrides *strings.Replacer // nil without a dictionary
+}
+
+// newTransliterator returns a transliterator with the overrides of a
+// dictionary, which has lines of the text to replace and its
+// replacement separated with a tab.  Blank lines and lines starting
+// with '#' are ignored.  A nil dictionary gives no overrides.
+func newTransliterator(dict io.Reader) (*transliterator, error) {
+	t := &transliterator{}
+	if dict == nil {
+		return t, nil
+	}
+	var pairs [][2]string
+	s := bufio.NewScanner(dict)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return nil, fmt.Errorf("Line %d of the dictionary has no tab: %s", n, line)
+		}
+		pairs = append(pairs, [2]string{fields[0], fields[1]})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	// strings.Replacer prefers the pair given first, so the longest text
+	// takes precedence over its prefixes
+	sort.SliceStable(pairs, func(i, j int) bool { return len(pairs[i][0]) > len(pairs[j][0]) })
+	var oldnew []string
+	for _, p := range pairs {
+		oldnew = append(oldnew, p[0], p[1])
+	}
+	t.overrides = strings.NewReplacer(oldnew...)
+	return t, nil
+}
+
+// Transliterate replaces text found in the dictionary, then folds
+// full-width ASCII and half-width kana, romanises kana in the Hepburn
+// system, romanises Cyrillic and Greek, and strips accents by Unicode
+// decomposition.  Other characters, such as kanji, are kept.
+func (t *transliterator) Transliterate(s string) string {
+	if t.overrides != nil {
+		s = t.overrides.Replace(s)
+	}
+	s = norm.NFC.String(width.Fold.String(s))
+	s = romaniseKana(s)
+	var b strings.Builder
+	for _, r := range s {
+		lower := unicode.ToLower(r)
+		latin, ok := cyrillicLatin[lower]
+		if !ok && unicode.Is(unicode.Greek, r) {
+			base, _ := utf8.DecodeRuneInString(norm.NFD.String(string(lower)))
+			latin, ok = greekLatin[base]
+		}
+		if !ok {
+			latin, ok = latinLetters[lower]
+		}
+		switch {
+		case !ok:
+			b.WriteRune(r)
+		case r != lower && latin != "":
+			b.WriteString(strings.ToUpper(latin[:1]) + latin[1:])
+		default:
+			b.WriteString(latin)
+		}
+	}
+	stripped, _, _ := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), b.String())
+	return strings.TrimSpace(stripped)
+}
+
+// romaniseKana romanises the hiragana and katakana of a string.  A small
+// tsu doubles the consonant following it, and a prolonged sound mark
+// repeats the vowel before it.
+func romaniseKana(s string) string {
+	rs := []rune(s)
+	for i, r := range rs {
+		if r >= 'ァ' && r <= 'ヶ' {
+			rs[i] = r - 0x60
+		}
+	}
+	var b strings.Builder
+	double := false
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch r {
+		case 'っ':
+			double = true
+			continue
+		case 'ー':
+			out := b.String()
+			if j := strings.LastIndexAny(out, "aeiou"); j >= 0 && j == len(out)-1 {
+				b.WriteByte(out[j])
+			}
+			continue
+		}
+		romaji, ok := "", false
+		if i+1 < len(rs) {
+			if romaji, ok = kanaRomaji[string(rs[i:i+2])]; ok {
+				i++
+			}
+		}
+		if !ok {
+			romaji, ok = kanaRomaji[string(r)]
+		}
+		if !ok {
+			double = false
+			b.WriteRune(r)
+			continue
+		}
+		if double && romaji != "" && strings.IndexByte("aeiou", romaji[0]) < 0 {
+			if strings.HasPrefix(romaji, "ch") {
+				b.WriteByte('t')
+			} else {
+				b.WriteByte(romaji[0])
+			}
+		}
+		double = false
+		b.WriteString(romaji)
+	}
+	return b.String()
+}
+
+// Fields returns a copy of the fields with their text transliterated.
+func (t *transliterator) Fields(v ID3v1Fields) *ID3v1Fields {
+	v.Title = t.Transliterate(v.Title)
+	v.Artist = t.Transliterate(v.Artist)
+	v.Album = t.Transliterate(v.Album)
+	v.Comment = t.Transliterate(v.Comment)
+	return &v
+}
+
+// loadTransliterator returns a transliterator with the overrides of a
+// dictionary file, or none if the file name is empty.
+func loadTransliterator(dictionary string) (*transliterator, error) {
+	if len(dictionary) == 0 {
+		return newTransliterator(nil)
+	}
+	f, err := os.Open(dictionary)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := newTransliterator(f)
+	if err != nil {
+		return nil, id3Error{dictionary, err.Error()}
+	}
+	return t, nil
+}
@@ -0,0 +1,59 @@
+// +build unittest
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTransliterate(t *testing.T) {
+	tr, err := newTransliterator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		text     string
+		expected string
+	}{
+		{"さくら", "sakura"},
+		{"ラーメン", "raamen"},
+		{"キャッチ", "kyatchi"},
+		{"がっこう", "gakkou"},
+		{"ｶﾞｯｺｳ", "gakkou"},
+		{"ヴァイオリン", "vaiorin"},
+		{"ＡＢＣ　１２３", "ABC 123"},
+		{"Чайковский", "Chaykovskiy"},
+		{"Ґалаґан Її", "Galagan Yiyi"},
+		{"Ελληνικά", "Ellinika"},
+		{"Café Ölçü Straße", "Cafe Olcu Strasse"},
+		{"東京タワー", "東京tawaa"},
+	} {
+		if got := tr.Transliterate(tt.text); got != tt.expected {
+			t.Errorf("Transliterate(%q) = %q, expected %q", tt.text, got, tt.expected)
+		}
+	}
+}
+
+func TestTransliterateDictionary(t *testing.T) {
+	dict := "# names transliterating badly\n東京\tTokyo \n東京タワー\tTokyo Tower\nこんにちは\tkonnichiwa\n\n"
+	tr, err := newTransliterator(strings.NewReader(dict))
+	if err != nil {
+		t.Fatalf("newTransliterator() error = %v", err)
+	}
+	for _, tt := range []struct {
+		text     string
+		expected string
+	}{
+		{"東京タワー", "Tokyo Tower"},
+		{"東京ラブストーリー", "Tokyo rabusutoorii"},
+		{"こんにちは、世界", "konnichiwa, 世界"},
+	} {
+		if got := tr.Transliterate(tt.text); got != tt.expected {
+			t.Errorf("Transliterate(%q) = %q, expected %q", tt.text, got, tt.expected)
+		}
+	}
+	if _, err := newTransliterator(strings.NewReader("東京 Tokyo\n")); err == nil {
+		t.Error("newTransliterator() accepted a line without a tab")
+	}
+}
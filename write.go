@@ -0,0 +1,220 @@
+/*
+ * Copyright (C) 2016 Upper Stream Software.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fileSplice replaces Length bytes at Offset of a file with Data.
+type fileSplice struct {
+	Offset int64
+	Length int64
+	Data   []byte
+}
+
+// fileMeta is the metadata of a file that writes preserve.
+type fileMeta struct {
+	Mode       os.FileMode
+	ModTime    time.Time
+	AccessTime time.Time
+	UID, GID   int // -1 where the platform has no ownership
+}
+
+// statFileMeta reads the metadata of a file.
+func statFileMeta(stat os.FileInfo) fileMeta {
+	uid, gid := fileOwner(stat)
+	return fileMeta{
+		Mode:       stat.Mode(),
+		ModTime:    stat.ModTime(),
+		AccessTime: fileAccessTime(stat),
+		UID:        uid,
+		GID:        gid,
+	}
+}
+
+// restoreTimes sets the access and modification times of a file back.
+func (m fileMeta) restoreTimes(pathname string) error {
+	return os.Chtimes(pathname, m.AccessTime, m.ModTime)
+}
+
+// splicedSize returns the size of a file of the given size after the
+// splices.
+func splicedSize(size int64, splices []fileSplice) int64 {
+	for _, s := range splices {
+		size += int64(len(s.Data)) - s.Length
+	}
+	return size
+}
+
+// writeSplices applies splices, sorted by offset and not overlapping, to
+// a file, keeping its mode, ownership and access and modification times.
+// A single splice running to the end of the file, such as writing an
+// ID3v1 tag, is written in place, synced and read back for verification.
+// Any other change writes a temporary file next to the file, syncs it,
+// and renames it over the file, so that a crash leaves either the old or
+// the new file.  A symbolic link is followed to the file it points to,
+// and a file with hard links is rewritten in place to keep them.
+func writeSplices(pathname string, splices []fileSplice) error {
+	pathname, err := filepath.EvalSymlinks(pathname)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(pathname)
+	if err != nil {
+		return err
+	}
+	sort.Slice(splices, func(i, j int) bool { return splices[i].Offset < splices[j].Offset })
+	for _, s := range splices {
+		if s.Offset < 0 || s.Length < 0 || s.Offset+s.Length > stat.Size() {
+			return id3Error{pathname, "Cannot write beyond the end of file"}
+		}
+	}
+	meta := statFileMeta(stat)
+	if len(splices) == 1 && splices[0].Offset+splices[0].Length == stat.Size() {
+		err = writeTailInPlace(pathname, splices[0])
+	} else if fileLinks(stat) > 1 {
+		err = rewriteInPlace(pathname, stat.Size(), splices)
+	} else {
+		err = rewriteFile(pathname, stat.Size(), splices, meta)
+	}
+	if err != nil {
+		return err
+	}
+	return meta.restoreTimes(pathname)
+}
+
+// writeTailInPlace replaces the tail of a file from the offset of the
+// splice, and verifies the new tail.
+func writeTailInPlace(pathname string, s fileSplice) error {
+	f, err := os.OpenFile(pathname, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(s.Data, s.Offset); err != nil {
+		return err
+	}
+	if err := f.Truncate(s.Offset + int64(len(s.Data))); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	b := make([]byte, len(s.Data))
+	if _, err := f.ReadAt(b, s.Offset); err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Equal(b, s.Data) {
+		return id3Error{pathname, "Written tag does not read back"}
+	}
+	return f.Close()
+}
+
+// splicedReader reads a file of the given size with the splices
+// applied.
+func splicedReader(src io.ReaderAt, size int64, splices []fileSplice) io.Reader {
+	var readers []io.Reader
+	var pos int64
+	for _, s := range splices {
+		readers = append(readers, io.NewSectionReader(src, pos, s.Offset-pos), bytes.NewReader(s.Data))
+		pos = s.Offset + s.Length
+	}
+	readers = append(readers, io.NewSectionReader(src, pos, size-pos))
+	return io.MultiReader(readers...)
+}
+
+// rewriteInPlace overwrites a file with its content with the splices
+// applied, read into memory first.  Unlike rewriteFile, a crash may
+// leave the file half written, but its hard links keep sharing it.
+func rewriteInPlace(pathname string, size int64, splices []fileSplice) error {
+	f, err := os.OpenFile(pathname, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(splicedReader(f, size, splices))
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(b, 0); err != nil {
+		return err
+	}
+	if err := f.Truncate(int64(len(b))); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// rewriteFile writes a copy of a file with the splices applied to a
+// temporary file in the same directory, and renames it over the file.
+// Failing to give the copy the ownership of the file, as a user outside
+// the group of a file writable to them does, is only warned about.
+func rewriteFile(pathname string, size int64, splices []fileSplice, meta fileMeta) error {
+	src, err := os.Open(pathname)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dir := filepath.Dir(pathname)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(pathname)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err := io.Copy(tmp, splicedReader(src, size, splices)); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(meta.Mode.Perm()); err != nil {
+		return err
+	}
+	if err := chownFile(tmp, meta); errors.Is(err, os.ErrPermission) {
+		fmt.Fprintln(os.Stderr, "Warning:", id3Error{pathname, "Cannot keep the ownership of file"})
+	} else if err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := meta.restoreTimes(tmp.Name()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), pathname); err != nil {
+		return err
+	}
+	done = true
+	return syncDir(dir)
+}
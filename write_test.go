@@ -0,0 +1,98 @@
+// +build unittest
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteSplices(t *testing.T) {
+	atime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	mtime := time.Date(2002, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		name     string
+		splices  []fileSplice
+		expected string
+	}{
+		{"Append", []fileSplice{{10, 0, []byte("TAG")}}, "0123456789TAG"},
+		{"Overwrite tail", []fileSplice{{7, 3, []byte("TAG")}}, "0123456TAG"},
+		{"Truncate tail", []fileSplice{{6, 4, nil}}, "012345"},
+		{"Replace head", []fileSplice{{0, 2, []byte("ID3")}}, "ID323456789"},
+		{"Replace head and tail", []fileSplice{{8, 2, []byte("TAG")}, {0, 1, nil}}, "1234567TAG"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.mp3")
+			if err := os.WriteFile(path, []byte("0123456789"), 0640); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, atime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			if err := writeSplices(path, tt.splices); err != nil {
+				t.Fatalf("writeSplices() error = %v", err)
+			}
+			// Stat before reading the content, which updates the access time
+			stat, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.Mode().Perm() != 0640 {
+				t.Errorf("mode = %v, expected 0640", stat.Mode().Perm())
+			}
+			if !stat.ModTime().Equal(mtime) {
+				t.Errorf("modification time = %v, expected %v", stat.ModTime(), mtime)
+			}
+			if got := fileAccessTime(stat); !got.Equal(atime) {
+				t.Errorf("access time = %v, expected %v", got, atime)
+			}
+			if b, _ := os.ReadFile(path); string(b) != tt.expected {
+				t.Errorf("content = %q, expected %q", b, tt.expected)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("%d files left in the directory, expected 1", len(entries))
+			}
+		})
+	}
+}
+
+func TestWriteSplicesBeyondEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSplices(path, []fileSplice{{8, 3, []byte("TAG")}}); err == nil {
+		t.Error("writeSplices() succeeded beyond the end of file")
+	}
+}
+
+func TestWriteSplicesLinks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.mp3")
+	symlink := filepath.Join(dir, "symlink.mp3")
+	hardlink := filepath.Join(dir, "hardlink.mp3")
+	if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("test.mp3", symlink); err != nil {
+		t.Skipf("Cannot make a symbolic link: %v", err)
+	}
+	if err := os.Link(path, hardlink); err != nil {
+		t.Skipf("Cannot make a hard link: %v", err)
+	}
+	if err := writeSplices(symlink, []fileSplice{{0, 2, []byte("ID3")}}); err != nil {
+		t.Fatalf("writeSplices() error = %v", err)
+	}
+	if stat, err := os.Lstat(symlink); err != nil || stat.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symbolic link replaced: %v, %v", stat, err)
+	}
+	for _, p := range []string{path, symlink, hardlink} {
+		if b, _ := os.ReadFile(p); string(b) != "ID323456789" {
+			t.Errorf("content of %s = %q, expected %q", filepath.Base(p), b, "ID323456789")
+		}
+	}
+}